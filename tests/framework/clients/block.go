@@ -72,6 +72,11 @@ func (b *BlockOperation) CreateStorageClass(poolName, storageClassName, reclaimP
 	return b.k8sClient.ResourceOperation("create", b.manifests.GetBlockStorageClassDef(poolName, storageClassName, reclaimPolicy, namespace, varClusterName))
 }
 
+// CreateStorageClassWithMetadata creates the block storage class with the given labels and annotations
+func (b *BlockOperation) CreateStorageClassWithMetadata(poolName, storageClassName, reclaimPolicy, namespace string, labels, annotations map[string]string) (string, error) {
+	return b.k8sClient.ResourceOperation("create", b.manifests.GetBlockStorageClassWithMetadata(poolName, storageClassName, reclaimPolicy, namespace, false, labels, annotations))
+}
+
 func (b *BlockOperation) DeletePvc(claimName, storageClassName, mode string) error {
 	_, err := b.k8sClient.ResourceOperation("delete", b.manifests.GetBlockPvcDef(claimName, storageClassName, mode))
 	return err
@@ -40,8 +40,14 @@ func CreateFilesystemOperation(k8sh *utils.K8sHelper, manifests installer.CephMa
 
 // Create creates a filesystem in Rook
 func (f *FilesystemOperation) Create(name, namespace string) error {
+	return f.CreateWithStandby(name, namespace, 2, true)
+}
+
+// CreateWithStandby creates a filesystem in Rook with the given number of active mds, and waits for the active and
+// standby mds pods to run. The standbys are in standby-replay if activeStandby is set.
+func (f *FilesystemOperation) CreateWithStandby(name, namespace string, activeCount int, activeStandby bool) error {
 	logger.Infof("creating the filesystem via CRD")
-	if _, err := f.k8sh.ResourceOperation("create", f.manifests.GetFilesystem(namespace, name, 2)); err != nil {
+	if _, err := f.k8sh.ResourceOperation("create", f.manifests.GetFilesystemWithStandby(namespace, name, activeCount, activeStandby)); err != nil {
 		return err
 	}
 
@@ -49,8 +55,9 @@ func (f *FilesystemOperation) Create(name, namespace string) error {
 	err := f.k8sh.WaitForLabeledPodsToRun(fmt.Sprintf("rook_file_system=%s", name), namespace)
 	assert.Nil(f.k8sh.T(), err)
 
-	assert.True(f.k8sh.T(), f.k8sh.CheckPodCountAndState("rook-ceph-mds", namespace, 4, "Running"),
-		"Make sure there are four rook-ceph-mds pods present in Running state")
+	podCount := installer.MDSPodCount(activeCount)
+	assert.True(f.k8sh.T(), f.k8sh.CheckPodCountAndState("rook-ceph-mds", namespace, podCount, "Running"),
+		fmt.Sprintf("Make sure there are %d rook-ceph-mds pods present in Running state", podCount))
 
 	return nil
 }
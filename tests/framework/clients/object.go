@@ -41,9 +41,22 @@ func CreateObjectOperation(k8sh *utils.K8sHelper, manifests installer.CephManife
 
 // ObjectCreate Function to create a object store in rook
 func (o *ObjectOperation) Create(namespace, storeName string, replicaCount int32) error {
+	return o.create(namespace, storeName, o.manifests.GetObjectStore(namespace, storeName, int(replicaCount), rgwPort))
+}
+
+// CreateWithPools creates an object store with the given config of the metadata and data pools
+func (o *ObjectOperation) CreateWithPools(namespace, storeName string, replicaCount int32, metadataPool, dataPool installer.ObjectPoolSpec) error {
+	return o.create(namespace, storeName, o.manifests.GetObjectStoreWithPools(namespace, storeName, int(replicaCount), rgwPort, metadataPool, dataPool))
+}
+
+// CreateWithDNSNames creates an object store that also serves virtual-hosted-style requests for the dns names
+func (o *ObjectOperation) CreateWithDNSNames(namespace, storeName string, replicaCount int32, dnsNames []string) error {
+	return o.create(namespace, storeName, o.manifests.GetObjectStoreWithDNSNames(namespace, storeName, int(replicaCount), rgwPort, dnsNames))
+}
 
+func (o *ObjectOperation) create(namespace, storeName, manifest string) error {
 	logger.Infof("creating the object store via CRD")
-	if _, err := o.k8sh.ResourceOperation("create", o.manifests.GetObjectStore(namespace, storeName, int(replicaCount), rgwPort)); err != nil {
+	if _, err := o.k8sh.ResourceOperation("create", manifest); err != nil {
 		return err
 	}
 
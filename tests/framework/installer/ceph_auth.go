@@ -0,0 +1,112 @@
+/*
+Copyright 2019 The Rook Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package installer
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+var (
+	// prefixes of the auth entities that belong to the cluster daemons rather than to the test resources.
+	// Used to detect leaks when no baseline was recorded, for example against an external cluster.
+	clusterAuthPrefixes = []string{"mon.", "mgr.", "osd.", "client.admin", "client.bootstrap-", "client.rbd-mirror."}
+)
+
+// ListAuthEntities returns the names of all the entities reported by "ceph auth ls"
+func (h *CephInstaller) ListAuthEntities(namespace string) ([]string, error) {
+	output, err := h.execCephCommand(namespace, "auth", "ls")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list auth entities. %+v", err)
+	}
+	var auth struct {
+		Entries []struct {
+			Entity string `json:"entity"`
+		} `json:"auth_dump"`
+	}
+	if err := json.Unmarshal(output, &auth); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal auth ls response: %s. %+v", string(output), err)
+	}
+
+	var entities []string
+	for _, entry := range auth.Entries {
+		entities = append(entities, entry.Entity)
+	}
+	return entities, nil
+}
+
+// RecordAuthEntities saves the current auth entities of the cluster as the baseline for VerifyNoLeakedAuth.
+// The install records the baseline automatically after the toolbox is started.
+func (h *CephInstaller) RecordAuthEntities(namespace string) error {
+	entities, err := h.ListAuthEntities(namespace)
+	if err != nil {
+		return err
+	}
+	if h.authBaseline == nil {
+		h.authBaseline = map[string][]string{}
+	}
+	h.authBaseline[namespace] = entities
+	logger.Infof("recorded %d auth entities for cluster %s", len(entities), namespace)
+	return nil
+}
+
+// VerifyNoLeakedAuth confirms the auth entities created by the tests were removed, such as the keys of object stores,
+// filesystems or csi users. Any entity not in the recorded baseline is reported as leaked. Without a baseline, any entity
+// that does not belong to the core cluster daemons is reported. Must be called while the toolbox is still running.
+func (h *CephInstaller) VerifyNoLeakedAuth(namespace string) error {
+	entities, err := h.ListAuthEntities(namespace)
+	if err != nil {
+		return err
+	}
+
+	baseline, ok := h.authBaseline[namespace]
+	leaked := findLeakedAuthEntities(entities, baseline, ok)
+	if len(leaked) > 0 {
+		return fmt.Errorf("found %d leaked auth entities in cluster %s: %s", len(leaked), namespace, strings.Join(leaked, ", "))
+	}
+	logger.Infof("no leaked auth entities in cluster %s", namespace)
+	return nil
+}
+
+func findLeakedAuthEntities(entities, baseline []string, hasBaseline bool) []string {
+	expected := map[string]bool{}
+	for _, entity := range baseline {
+		expected[entity] = true
+	}
+
+	var leaked []string
+	for _, entity := range entities {
+		if hasBaseline {
+			if !expected[entity] {
+				leaked = append(leaked, entity)
+			}
+		} else if !isClusterAuthEntity(entity) {
+			leaked = append(leaked, entity)
+		}
+	}
+	return leaked
+}
+
+func isClusterAuthEntity(entity string) bool {
+	for _, prefix := range clusterAuthPrefixes {
+		if strings.HasPrefix(entity, prefix) {
+			return true
+		}
+	}
+	return false
+}
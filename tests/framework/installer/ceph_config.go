@@ -0,0 +1,294 @@
+/*
+Copyright 2019 The Rook Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package installer
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rook/rook/pkg/operator/k8sutil"
+	"github.com/rook/rook/tests/framework/utils"
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var (
+	// the pod label with the ceph id of the daemons of each type that can be restarted to apply a config override
+	daemonIDLabels = map[string]string{
+		"mon": "mon",
+		"mgr": "mgr",
+		"osd": osdIDLabel,
+	}
+	// the order the daemons of each type are restarted, so the mons are back in quorum before the other daemons
+	daemonRestartOrder = []string{"mon", "mgr", "osd"}
+)
+
+// ConfigChange is a setting that differs between two config dumps. Before is empty for an added setting and
+// After is empty for a removed setting.
+type ConfigChange struct {
+	Key    string
+	Before string
+	After  string
+}
+
+// GetConfigDump returns the settings of the mon config database from "ceph config dump" keyed by
+// "<section>/<name>", or "<section>/<mask>/<name>" for masked settings. This requires mimic or newer.
+func (h *CephInstaller) GetConfigDump(namespace string) (map[string]string, error) {
+	output, err := h.execCephCommand(namespace, "config", "dump")
+	if err != nil {
+		return nil, fmt.Errorf("failed to dump the ceph config. %+v", err)
+	}
+	return parseConfigDump(output)
+}
+
+func parseConfigDump(output []byte) (map[string]string, error) {
+	var entries []struct {
+		Section string `json:"section"`
+		Mask    string `json:"mask"`
+		Name    string `json:"name"`
+		Value   string `json:"value"`
+	}
+	if err := json.Unmarshal(output, &entries); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal the config dump: %s. %+v", string(output), err)
+	}
+
+	config := map[string]string{}
+	for _, entry := range entries {
+		key := entry.Section + "/" + entry.Name
+		if entry.Mask != "" {
+			key = entry.Section + "/" + entry.Mask + "/" + entry.Name
+		}
+		config[key] = entry.Value
+	}
+	return config, nil
+}
+
+// DiffConfigDump returns the settings that were added, removed or changed between the two config dumps, sorted by key
+func DiffConfigDump(before, after map[string]string) []ConfigChange {
+	var changes []ConfigChange
+	for key, value := range before {
+		if newValue, ok := after[key]; !ok || newValue != value {
+			changes = append(changes, ConfigChange{Key: key, Before: value, After: newValue})
+		}
+	}
+	for key, value := range after {
+		if _, ok := before[key]; !ok {
+			changes = append(changes, ConfigChange{Key: key, After: value})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Key < changes[j].Key })
+	return changes
+}
+
+// SetCephConfigAndVerifyPersistence sets the config in the section given by who, such as global, osd or mon.a, through
+// the rook-config-override configmap of the cluster. The overrides only reach the ceph.conf of the daemons, so the
+// value is also set in the mon config database with "ceph config set". The daemons of the section are restarted one at
+// a time, waiting for the mons to be in quorum and ceph to be healthy after each restart, and the value is read back
+// for each restarted daemon with "ceph config get".
+func (h *CephInstaller) SetCephConfigAndVerifyPersistence(namespace, who, key, value string) error {
+	selectors, err := configDaemonSelectors(who)
+	if err != nil {
+		return err
+	}
+	if err := h.setConfigOverride(namespace, who, key, value); err != nil {
+		return err
+	}
+	if _, err := h.execCephCommand(namespace, "config", "set", who, key, value); err != nil {
+		return fmt.Errorf("failed to set %s of %s in the config database. %+v", key, who, err)
+	}
+
+	for _, daemonType := range daemonRestartOrder {
+		selector, ok := selectors[daemonType]
+		if !ok {
+			continue
+		}
+		pods, err := h.restartPods(namespace, selector)
+		if err != nil {
+			return err
+		}
+		for _, pod := range pods {
+			daemon := daemonType + "." + pod.Labels[daemonIDLabels[daemonType]]
+			actual, err := h.getStoredConfig(namespace, daemon, key)
+			if err != nil {
+				return err
+			}
+			if actual != value {
+				return fmt.Errorf("%s of %s is %q after the restart instead of %q", key, daemon, actual, value)
+			}
+			logger.Infof("%s of %s is %q after the restart", key, daemon, actual)
+		}
+	}
+	return nil
+}
+
+// configDaemonSelectors returns the label selectors of the daemons that load the ceph.conf section, keyed by the
+// daemon type. The global section is loaded by all the mons, mgrs and osds.
+func configDaemonSelectors(who string) (map[string]string, error) {
+	if who == "global" {
+		selectors := map[string]string{}
+		for daemonType := range daemonIDLabels {
+			selectors[daemonType] = "app=rook-ceph-" + daemonType
+		}
+		return selectors, nil
+	}
+
+	parts := strings.SplitN(who, ".", 2)
+	idLabel, ok := daemonIDLabels[parts[0]]
+	if !ok {
+		return nil, fmt.Errorf("cannot restart the daemons of config section %q", who)
+	}
+	selector := "app=rook-ceph-" + parts[0]
+	if len(parts) == 2 {
+		selector += "," + idLabel + "=" + parts[1]
+	}
+	return map[string]string{parts[0]: selector}, nil
+}
+
+// setConfigOverride sets the key in the section of the rook-config-override configmap, which is created if the cluster
+// was created without overrides
+func (h *CephInstaller) setConfigOverride(namespace, section, key, value string) error {
+	configMaps := h.k8shelper.Clientset.CoreV1().ConfigMaps(namespace)
+	cm, err := configMaps.Get(k8sutil.ConfigOverrideName, metav1.GetOptions{})
+	if err != nil {
+		if !errors.IsNotFound(err) {
+			return fmt.Errorf("failed to get the config override configmap. %+v", err)
+		}
+		cm = &v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: k8sutil.ConfigOverrideName, Namespace: namespace}}
+	}
+
+	overrides := parseCephConfig(cm.Data[k8sutil.ConfigOverrideVal])
+	if overrides[section] == nil {
+		overrides[section] = map[string]string{}
+	}
+	overrides[section][key] = value
+	if cm.Data == nil {
+		cm.Data = map[string]string{}
+	}
+	cm.Data[k8sutil.ConfigOverrideVal] = strings.TrimPrefix(renderCephConfig(overrides, 0), "\n") + "\n"
+
+	if cm.ResourceVersion == "" {
+		_, err = configMaps.Create(cm)
+	} else {
+		_, err = configMaps.Update(cm)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to set %s in section %s of the config override configmap. %+v", key, section, err)
+	}
+	logger.Infof("set %s = %s in section %s of the config overrides", key, value, section)
+	return nil
+}
+
+// parseCephConfig returns the settings of the ini format of ceph.conf keyed by section and then by setting name.
+// Comments and the lines outside of a section are ignored.
+func parseCephConfig(config string) map[string]map[string]string {
+	settings := map[string]map[string]string{}
+	section := ""
+	for _, line := range strings.Split(config, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			if settings[section] == nil {
+				settings[section] = map[string]string{}
+			}
+			continue
+		}
+		parts := strings.SplitN(line, "=", 2)
+		if section == "" || len(parts) != 2 {
+			continue
+		}
+		settings[section][strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+	}
+	return settings
+}
+
+// restartPods restarts the pods with the label one at a time. After each pod is deleted the same number of pods must
+// be running without the deleted pod, the mons must be in quorum and ceph must not report a health error before the
+// next pod is restarted. The running pods are returned.
+func (h *CephInstaller) restartPods(namespace, selector string) ([]v1.Pod, error) {
+	pods, err := h.k8shelper.Clientset.CoreV1().Pods(namespace).List(metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the pods with label %s. %+v", selector, err)
+	}
+	if len(pods.Items) == 0 {
+		return nil, fmt.Errorf("no pods found with label %s", selector)
+	}
+	names := []string{}
+	for _, pod := range pods.Items {
+		names = append(names, pod.Name)
+	}
+	sort.Strings(names)
+	logger.Infof("restarting %d pods with label %s one at a time", len(names), selector)
+
+	var running []v1.Pod
+	for _, name := range names {
+		if running, err = h.restartPod(namespace, selector, name, len(names)); err != nil {
+			return nil, err
+		}
+	}
+	return running, nil
+}
+
+// restartPod deletes the pod and waits for its replacement and for the cluster to be healthy again
+func (h *CephInstaller) restartPod(namespace, selector, name string, count int) ([]v1.Pod, error) {
+	if err := h.k8shelper.Clientset.CoreV1().Pods(namespace).Delete(name, &metav1.DeleteOptions{}); err != nil {
+		return nil, fmt.Errorf("failed to delete pod %s. %+v", name, err)
+	}
+	var err error
+	for i := 0; i < utils.RetryLoop; i++ {
+		var pods *v1.PodList
+		if pods, err = h.k8shelper.Clientset.CoreV1().Pods(namespace).List(metav1.ListOptions{LabelSelector: selector}); err == nil {
+			if !podsReplaced(pods.Items, name, count) {
+				err = fmt.Errorf("pod %s is not replaced yet", name)
+			} else if err = h.verifyCephHealthy(namespace); err == nil {
+				logger.Infof("pod %s was restarted", name)
+				return pods.Items, nil
+			}
+		}
+		logger.Infof("waiting for pod %s to restart. %v", name, err)
+		time.Sleep(utils.RetryInterval * time.Second)
+	}
+	return nil, fmt.Errorf("gave up waiting for pod %s to restart. %v", name, err)
+}
+
+// podsReplaced returns whether the expected number of pods are running and none of them is the deleted pod
+func podsReplaced(pods []v1.Pod, deleted string, count int) bool {
+	if len(pods) != count {
+		return false
+	}
+	for _, pod := range pods {
+		if pod.Name == deleted || pod.Status.Phase != v1.PodRunning {
+			return false
+		}
+	}
+	return true
+}
+
+// getStoredConfig returns the value of the setting of the daemon in the mon config database from "ceph config get"
+func (h *CephInstaller) getStoredConfig(namespace, daemon, key string) (string, error) {
+	output, err := h.execCephCommand(namespace, "config", "get", daemon, key)
+	if err != nil {
+		return "", fmt.Errorf("failed to get %s of %s from the config database. %+v", key, daemon, err)
+	}
+	return parseConfigValue(output), nil
+}
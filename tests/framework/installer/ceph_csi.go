@@ -0,0 +1,569 @@
+/*
+Copyright 2019 The Rook Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package installer
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rook/rook/pkg/daemon/ceph/client"
+	"github.com/rook/rook/tests/framework/utils"
+	"k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/kubernetes/pkg/kubelet/apis"
+)
+
+const (
+	csiTestImage = "busybox"
+	// the claims are polled more often than the usual retry interval to measure the bind latency precisely
+	bindPollInterval = time.Second
+	bindTimeout      = utils.RetryLoop * utils.RetryInterval * time.Second
+
+	rbdDriverName    = "csi-rbdplugin"
+	cephfsDriverName = "csi-cephfsplugin"
+)
+
+// GatherCSILogs collects the logs of the csi provisioners and plugins from the system namespace of the cluster
+func (h *CephInstaller) GatherCSILogs(namespace, testName string) {
+	systemNamespace := SystemNamespace(namespace)
+	logger.Infof("Gathering csi logs from namespace %s", systemNamespace)
+	for _, app := range []string{"csi-rbdplugin-provisioner", "csi-rbdplugin-attacher", "csi-rbdplugin", "csi-cephfsplugin-provisioner", "csi-cephfsplugin"} {
+		h.k8shelper.GetRookLogs(app, Env.HostType, systemNamespace, testName)
+	}
+}
+
+// VerifyTopologyAwareProvisioning provisions a volume from a copy of the storage class that only allows the given zone.
+// The volume must be bound to the zone by the provisioner, the pod consuming it must be scheduled in the zone, and the
+// rbd image must exist in ceph. The csi logs are collected on failure.
+func (h *CephInstaller) VerifyTopologyAwareProvisioning(namespace, storageClass, zone string) error {
+	err := h.verifyTopologyAwareProvisioning(namespace, storageClass, zone)
+	if err != nil {
+		h.GatherCSILogs(namespace, "topology-"+zone)
+	}
+	return err
+}
+
+func (h *CephInstaller) verifyTopologyAwareProvisioning(namespace, storageClass, zone string) error {
+	base, err := h.k8shelper.Clientset.StorageV1().StorageClasses().Get(storageClass, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to get storage class %s. %+v", storageClass, err)
+	}
+
+	zonedClass := fmt.Sprintf("%s-%s", storageClass, zone)
+	reclaimPolicy := v1.PersistentVolumeReclaimDelete
+	bindingMode := storagev1.VolumeBindingWaitForFirstConsumer
+	sc := &storagev1.StorageClass{
+		ObjectMeta:        metav1.ObjectMeta{Name: zonedClass},
+		Provisioner:       base.Provisioner,
+		Parameters:        base.Parameters,
+		ReclaimPolicy:     &reclaimPolicy,
+		VolumeBindingMode: &bindingMode,
+		AllowedTopologies: []v1.TopologySelectorTerm{
+			{MatchLabelExpressions: []v1.TopologySelectorLabelRequirement{
+				{Key: apis.LabelZoneFailureDomain, Values: []string{zone}},
+			}},
+		},
+	}
+	if _, err := h.k8shelper.Clientset.StorageV1().StorageClasses().Create(sc); err != nil {
+		return fmt.Errorf("failed to create storage class %s. %+v", zonedClass, err)
+	}
+	defer h.k8shelper.Clientset.StorageV1().StorageClasses().Delete(zonedClass, nil)
+
+	pvcName := "topology-pvc-" + zone
+	podName := "topology-pod-" + zone
+	if err := h.createTestPVC(namespace, pvcName, zonedClass, "1Gi"); err != nil {
+		return err
+	}
+	defer h.k8shelper.Clientset.CoreV1().PersistentVolumeClaims(namespace).Delete(pvcName, nil)
+	if err := h.createTestPod(namespace, podName, pvcName); err != nil {
+		return err
+	}
+	defer h.k8shelper.Clientset.CoreV1().Pods(namespace).Delete(podName, nil)
+
+	// the volume is only provisioned when the pod is scheduled
+	if !h.k8shelper.IsPodRunning(podName, namespace) {
+		h.k8shelper.PrintPodDescribe(namespace, podName)
+		return fmt.Errorf("pod %s consuming the zoned volume is not running", podName)
+	}
+	pod, err := h.k8shelper.Clientset.CoreV1().Pods(namespace).Get(podName, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to get pod %s. %+v", podName, err)
+	}
+	node, err := h.k8shelper.Clientset.CoreV1().Nodes().Get(pod.Spec.NodeName, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to get node %s. %+v", pod.Spec.NodeName, err)
+	}
+	if node.Labels[apis.LabelZoneFailureDomain] != zone {
+		return fmt.Errorf("pod %s was scheduled on node %s in zone %q instead of %q", podName, node.Name, node.Labels[apis.LabelZoneFailureDomain], zone)
+	}
+
+	pv, err := h.getBoundPV(namespace, pvcName)
+	if err != nil {
+		return err
+	}
+	if !pvInZone(pv, zone) {
+		return fmt.Errorf("the provisioner did not restrict pv %s to zone %s. node affinity: %+v", pv.Name, zone, pv.Spec.NodeAffinity)
+	}
+	if err := h.verifyCSIImageExists(namespace, pv); err != nil {
+		return err
+	}
+
+	logger.Infof("volume %s was provisioned in zone %s", pv.Name, zone)
+	return nil
+}
+
+// CloneVolume creates a clone of the source claim with a pvc data source and verifies that a file written to the
+// source before the clone is found in the clone. The name of the clone is returned and the caller deletes it with the
+// source claim. On failure the csi logs are collected and the clone is deleted.
+func (h *CephInstaller) CloneVolume(namespace, sourcePVC string) (string, error) {
+	clonePVC := sourcePVC + "-clone"
+	if err := h.cloneVolume(namespace, sourcePVC, clonePVC); err != nil {
+		h.GatherCSILogs(namespace, "clone-"+sourcePVC)
+		h.k8shelper.Clientset.CoreV1().PersistentVolumeClaims(namespace).Delete(clonePVC, nil)
+		return "", err
+	}
+	logger.Infof("pvc %s is a clone of pvc %s", clonePVC, sourcePVC)
+	return clonePVC, nil
+}
+
+func (h *CephInstaller) cloneVolume(namespace, sourcePVC, clonePVC string) error {
+	source, err := h.k8shelper.Clientset.CoreV1().PersistentVolumeClaims(namespace).Get(sourcePVC, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to get pvc %s. %+v", sourcePVC, err)
+	}
+	filename := "clone-test"
+	message := "cloned from " + sourcePVC
+
+	// the writer is deleted before cloning so the data is flushed to the volume when it is unmounted
+	writer := sourcePVC + "-writer"
+	if err := h.runTestPod(namespace, writer, sourcePVC, func() error {
+		return h.k8shelper.WriteToPod(namespace, writer, filename, message)
+	}); err != nil {
+		return err
+	}
+
+	clone := &v1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{Name: clonePVC, Namespace: namespace},
+		Spec: v1.PersistentVolumeClaimSpec{
+			AccessModes:      source.Spec.AccessModes,
+			StorageClassName: source.Spec.StorageClassName,
+			Resources:        source.Spec.Resources,
+			DataSource:       &v1.TypedLocalObjectReference{Kind: "PersistentVolumeClaim", Name: sourcePVC},
+		},
+	}
+	if _, err := h.k8shelper.Clientset.CoreV1().PersistentVolumeClaims(namespace).Create(clone); err != nil {
+		return fmt.Errorf("failed to create pvc %s. %+v", clonePVC, err)
+	}
+	if _, err := h.getBoundPV(namespace, clonePVC); err != nil {
+		return err
+	}
+
+	reader := clonePVC + "-reader"
+	return h.runTestPod(namespace, reader, clonePVC, func() error {
+		return h.k8shelper.ReadFromPod(namespace, reader, filename, message)
+	})
+}
+
+// runTestPod runs the function while a test pod mounts the claim, and waits for the pod to be deleted afterwards
+func (h *CephInstaller) runTestPod(namespace, podName, pvcName string, f func() error) error {
+	if err := h.createTestPod(namespace, podName, pvcName); err != nil {
+		return err
+	}
+	defer func() {
+		h.k8shelper.Clientset.CoreV1().Pods(namespace).Delete(podName, nil)
+		h.k8shelper.WaitUntilPodIsDeleted(podName, namespace)
+	}()
+	if !h.k8shelper.IsPodRunning(podName, namespace) {
+		h.k8shelper.PrintPodDescribe(namespace, podName)
+		return fmt.Errorf("pod %s mounting pvc %s is not running", podName, pvcName)
+	}
+	return f()
+}
+
+// MeasureProvisioningLatency creates the claims from the storage class at the same time and returns the time it took
+// to bind each of them, in the order the claims were numbered. The claims are deleted at the end. The csi logs are
+// collected if a claim is not bound.
+func (h *CephInstaller) MeasureProvisioningLatency(namespace, storageClass string, count int) ([]time.Duration, error) {
+	if count <= 0 {
+		return nil, fmt.Errorf("the number of pvcs must be positive, got %d", count)
+	}
+	latencies := make([]time.Duration, count)
+	errs := make([]error, count)
+	var wg sync.WaitGroup
+	for i := 0; i < count; i++ {
+		name := fmt.Sprintf("latency-pvc-%d", i)
+		defer h.k8shelper.Clientset.CoreV1().PersistentVolumeClaims(namespace).Delete(name, nil)
+		wg.Add(1)
+		go func(i int, name string) {
+			defer wg.Done()
+			start := time.Now()
+			if errs[i] = h.createTestPVC(namespace, name, storageClass, "1Gi"); errs[i] != nil {
+				return
+			}
+			if errs[i] = h.waitForPVCBound(namespace, name); errs[i] == nil {
+				latencies[i] = time.Since(start)
+			}
+		}(i, name)
+	}
+	wg.Wait()
+
+	var failures []string
+	for i, err := range errs {
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("latency-pvc-%d: %+v", i, err))
+		}
+	}
+	if len(failures) > 0 {
+		h.GatherCSILogs(namespace, "provisioning-latency")
+		return latencies, fmt.Errorf("%d of %d claims were not bound: %s", len(failures), count, strings.Join(failures, "; "))
+	}
+	logger.Infof("bind latencies of %d claims from storage class %s: %v", count, storageClass, latencies)
+	return latencies, nil
+}
+
+// waitForPVCBound polls the claim until it is bound
+func (h *CephInstaller) waitForPVCBound(namespace, name string) error {
+	start := time.Now()
+	for {
+		pvc, err := h.k8shelper.Clientset.CoreV1().PersistentVolumeClaims(namespace).Get(name, metav1.GetOptions{})
+		if err == nil && pvc.Status.Phase == v1.ClaimBound {
+			return nil
+		}
+		if time.Since(start) > bindTimeout {
+			if err == nil {
+				err = fmt.Errorf("phase %s", pvc.Status.Phase)
+			}
+			return fmt.Errorf("gave up after %v waiting for pvc %s to be bound. %+v", bindTimeout, name, err)
+		}
+		time.Sleep(bindPollInterval)
+	}
+}
+
+// createTestPVC creates a RWO claim of the given size from the storage class
+func (h *CephInstaller) createTestPVC(namespace, name, storageClass, size string) error {
+	pvc := &v1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+		Spec: v1.PersistentVolumeClaimSpec{
+			AccessModes:      []v1.PersistentVolumeAccessMode{v1.ReadWriteOnce},
+			StorageClassName: &storageClass,
+			Resources: v1.ResourceRequirements{
+				Requests: v1.ResourceList{v1.ResourceStorage: resource.MustParse(size)},
+			},
+		},
+	}
+	if _, err := h.k8shelper.Clientset.CoreV1().PersistentVolumeClaims(namespace).Create(pvc); err != nil {
+		return fmt.Errorf("failed to create pvc %s. %+v", name, err)
+	}
+	return nil
+}
+
+// createTestPod creates a pod that mounts the claim at utils.TestMountPath
+func (h *CephInstaller) createTestPod(namespace, name, pvcName string) error {
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+		Spec: v1.PodSpec{
+			Containers: []v1.Container{{
+				Name:            name,
+				Image:           csiTestImage,
+				ImagePullPolicy: v1.PullIfNotPresent,
+				Command:         []string{"sh", "-c", "sleep 3600"},
+				VolumeMounts:    []v1.VolumeMount{{Name: "testvol", MountPath: utils.TestMountPath}},
+			}},
+			Volumes: []v1.Volume{{
+				Name: "testvol",
+				VolumeSource: v1.VolumeSource{
+					PersistentVolumeClaim: &v1.PersistentVolumeClaimVolumeSource{ClaimName: pvcName},
+				},
+			}},
+			RestartPolicy: v1.RestartPolicyNever,
+		},
+	}
+	if _, err := h.k8shelper.Clientset.CoreV1().Pods(namespace).Create(pod); err != nil {
+		return fmt.Errorf("failed to create pod %s. %+v", name, err)
+	}
+	return nil
+}
+
+// getBoundPV waits for the claim to be bound and returns its volume
+func (h *CephInstaller) getBoundPV(namespace, pvcName string) (*v1.PersistentVolume, error) {
+	if !h.k8shelper.WaitUntilPVCIsBound(namespace, pvcName) {
+		return nil, fmt.Errorf("pvc %s was not bound", pvcName)
+	}
+	volumeName, err := h.k8shelper.GetPVCVolumeName(namespace, pvcName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the volume of pvc %s. %+v", pvcName, err)
+	}
+	pv, err := h.k8shelper.GetPV(volumeName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get pv %s. %+v", volumeName, err)
+	}
+	return pv, nil
+}
+
+// verifyCSIImageExists checks that the rbd image backing the csi volume is found in its pool
+func (h *CephInstaller) verifyCSIImageExists(namespace string, pv *v1.PersistentVolume) error {
+	if pv.Spec.CSI == nil {
+		return fmt.Errorf("pv %s is not a csi volume", pv.Name)
+	}
+	pool, imageName, err := rbdImageOfPV(pv)
+	if err != nil {
+		return err
+	}
+	exists, err := h.rbdImageExists(namespace, pool, imageName)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("rbd image %s of pv %s not found in pool %s", imageName, pv.Name, pool)
+	}
+	return nil
+}
+
+// rbdImageOfPV returns the pool and the name of the rbd image backing a csi or flex volume
+func rbdImageOfPV(pv *v1.PersistentVolume) (string, string, error) {
+	if pv.Spec.CSI != nil {
+		imageName := pv.Spec.CSI.VolumeAttributes["imageName"]
+		if imageName == "" {
+			imageName = pv.Spec.CSI.VolumeHandle
+		}
+		return pv.Spec.CSI.VolumeAttributes["pool"], imageName, nil
+	}
+	if pv.Spec.FlexVolume != nil {
+		// the options set by the rook flex provisioner
+		pool := pv.Spec.FlexVolume.Options["pool"]
+		if pool == "" {
+			pool = pv.Spec.FlexVolume.Options["blockPool"]
+		}
+		return pool, pv.Spec.FlexVolume.Options["image"], nil
+	}
+	return "", "", fmt.Errorf("pv %s is not backed by an rbd image", pv.Name)
+}
+
+func (h *CephInstaller) rbdImageExists(namespace, pool, imageName string) (bool, error) {
+	images, err := client.ListImages(h.k8shelper.MakeContext(), namespace, pool)
+	if err != nil {
+		return false, fmt.Errorf("failed to list the images in pool %s. %+v", pool, err)
+	}
+	for _, image := range images {
+		if image.Name == imageName {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// VerifyReclaimPolicy provisions a volume from a copy of the storage class with the given reclaim policy, deletes
+// the claim, and checks in ceph that the rbd image is deleted with the Delete policy or retained with the Retain
+// policy. A retained volume and image are cleaned up afterwards.
+func (h *CephInstaller) VerifyReclaimPolicy(namespace, storageClass string, policy v1.PersistentVolumeReclaimPolicy) error {
+	if policy != v1.PersistentVolumeReclaimDelete && policy != v1.PersistentVolumeReclaimRetain {
+		return fmt.Errorf("unsupported reclaim policy %s", policy)
+	}
+	base, err := h.k8shelper.Clientset.StorageV1().StorageClasses().Get(storageClass, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to get storage class %s. %+v", storageClass, err)
+	}
+
+	policyClass := fmt.Sprintf("%s-%s", storageClass, strings.ToLower(string(policy)))
+	bindingMode := storagev1.VolumeBindingImmediate
+	sc := &storagev1.StorageClass{
+		ObjectMeta:        metav1.ObjectMeta{Name: policyClass},
+		Provisioner:       base.Provisioner,
+		Parameters:        base.Parameters,
+		ReclaimPolicy:     &policy,
+		VolumeBindingMode: &bindingMode,
+	}
+	if _, err := h.k8shelper.Clientset.StorageV1().StorageClasses().Create(sc); err != nil {
+		return fmt.Errorf("failed to create storage class %s. %+v", policyClass, err)
+	}
+	defer h.k8shelper.Clientset.StorageV1().StorageClasses().Delete(policyClass, nil)
+
+	pvcName := "reclaim-pvc-" + strings.ToLower(string(policy))
+	if err := h.createTestPVC(namespace, pvcName, policyClass, "1Gi"); err != nil {
+		return err
+	}
+	pv, err := h.getBoundPV(namespace, pvcName)
+	if err != nil {
+		h.k8shelper.Clientset.CoreV1().PersistentVolumeClaims(namespace).Delete(pvcName, nil)
+		return err
+	}
+	pool, imageName, err := rbdImageOfPV(pv)
+	if err != nil {
+		h.k8shelper.Clientset.CoreV1().PersistentVolumeClaims(namespace).Delete(pvcName, nil)
+		return err
+	}
+
+	if err := h.k8shelper.Clientset.CoreV1().PersistentVolumeClaims(namespace).Delete(pvcName, nil); err != nil {
+		return fmt.Errorf("failed to delete pvc %s. %+v", pvcName, err)
+	}
+	if policy == v1.PersistentVolumeReclaimRetain {
+		defer h.deleteRetainedVolume(namespace, pv.Name, pool, imageName)
+	}
+
+	var exists bool
+	for i := 0; i < utils.RetryLoop; i++ {
+		exists, err = h.rbdImageExists(namespace, pool, imageName)
+		if err == nil && exists == (policy == v1.PersistentVolumeReclaimRetain) {
+			if policy == v1.PersistentVolumeReclaimDelete || h.isPVReleased(pv.Name) {
+				logger.Infof("rbd image %s/%s of pv %s is %s after deleting the claim", pool, imageName, pv.Name, imageState(exists))
+				return nil
+			}
+		}
+		logger.Infof("waiting for the %s policy of pv %s to be applied. image %s. %v", policy, pv.Name, imageState(exists), err)
+		time.Sleep(utils.RetryInterval * time.Second)
+	}
+	return fmt.Errorf("rbd image %s/%s of pv %s with reclaim policy %s is %s after deleting the claim. %v",
+		pool, imageName, pv.Name, policy, imageState(exists), err)
+}
+
+func imageState(exists bool) string {
+	if exists {
+		return "retained"
+	}
+	return "deleted"
+}
+
+func (h *CephInstaller) isPVReleased(name string) bool {
+	pv, err := h.k8shelper.GetPV(name)
+	return err == nil && pv.Status.Phase == v1.VolumeReleased
+}
+
+// deleteRetainedVolume removes the pv and the rbd image that were kept by the Retain policy
+func (h *CephInstaller) deleteRetainedVolume(namespace, pvName, pool, imageName string) {
+	if err := h.k8shelper.Clientset.CoreV1().PersistentVolumes().Delete(pvName, nil); err != nil {
+		logger.Warningf("failed to delete retained pv %s. %+v", pvName, err)
+	}
+	if err := client.DeleteImage(h.k8shelper.MakeContext(), namespace, imageName, pool); err != nil {
+		logger.Warningf("failed to delete retained image %s/%s. %+v", pool, imageName, err)
+	}
+}
+
+// pvInZone returns whether the node affinity of the volume requires the zone, either with the well known zone label or a
+// zone topology key of the csi driver
+func pvInZone(pv *v1.PersistentVolume, zone string) bool {
+	if pv.Spec.NodeAffinity == nil || pv.Spec.NodeAffinity.Required == nil {
+		return false
+	}
+	for _, term := range pv.Spec.NodeAffinity.Required.NodeSelectorTerms {
+		for _, expr := range term.MatchExpressions {
+			if expr.Key != apis.LabelZoneFailureDomain && !strings.HasSuffix(expr.Key, "/zone") {
+				continue
+			}
+			for _, value := range expr.Values {
+				if value == zone {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// csiNodeList is the subset of the csinode objects needed to find the drivers registered by the kubelet on each node
+type csiNodeList struct {
+	Items []struct {
+		Metadata struct {
+			Name string `json:"name"`
+		} `json:"metadata"`
+		Spec struct {
+			Drivers []struct {
+				Name string `json:"name"`
+			} `json:"drivers"`
+		} `json:"spec"`
+	} `json:"items"`
+}
+
+// VerifyCSIDriverRegistered confirms the node plugins of the rbd and cephfs drivers are registered by the registrar in
+// the csinode object of every node running the plugin. The templates create no csidriver objects, so only the node
+// registration is checked. The nodes missing the registration are returned in the error.
+func (h *CephInstaller) VerifyCSIDriverRegistered() error {
+	drivers := []string{rbdDriverName, cephfsDriverName}
+	var missing map[string][]string
+	for i := 0; i < utils.RetryLoop; i++ {
+		output, err := h.k8shelper.Kubectl("get", "csinode", "-o", "json")
+		if err == nil {
+			missing = map[string][]string{}
+			for _, driver := range drivers {
+				var pluginNodes []string
+				if pluginNodes, err = h.csiPluginNodes(driver); err != nil {
+					break
+				}
+				var nodes []string
+				if nodes, err = unregisteredNodes([]byte(output), driver, pluginNodes); err != nil {
+					break
+				}
+				if len(nodes) > 0 {
+					missing[driver] = nodes
+				}
+			}
+			if err == nil && len(missing) == 0 {
+				logger.Infof("csi drivers %v are registered on all nodes", drivers)
+				return nil
+			}
+		}
+		logger.Infof("waiting for the csi drivers to be registered. missing=%v, err=%v", missing, err)
+		time.Sleep(utils.RetryInterval * time.Second)
+	}
+	return fmt.Errorf("csi drivers are not registered on nodes %v", missing)
+}
+
+// csiPluginNodes returns the nodes where the node plugin of the driver is running
+func (h *CephInstaller) csiPluginNodes(driver string) ([]string, error) {
+	pods, err := h.k8shelper.Clientset.CoreV1().Pods("").List(metav1.ListOptions{LabelSelector: "app=" + driver})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the %s pods. %+v", driver, err)
+	}
+	var nodes []string
+	for _, pod := range pods.Items {
+		if pod.Spec.NodeName != "" {
+			nodes = append(nodes, pod.Spec.NodeName)
+		}
+	}
+	if len(nodes) == 0 {
+		return nil, fmt.Errorf("no %s pods are scheduled", driver)
+	}
+	return nodes, nil
+}
+
+// unregisteredNodes returns the nodes whose csinode object in the output of "kubectl get csinode -o json" does not list
+// the driver
+func unregisteredNodes(output []byte, driver string, nodes []string) ([]string, error) {
+	var list csiNodeList
+	if err := json.Unmarshal(output, &list); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal csinodes. %+v", err)
+	}
+	registered := map[string]bool{}
+	for _, node := range list.Items {
+		for _, d := range node.Spec.Drivers {
+			if d.Name == driver {
+				registered[node.Metadata.Name] = true
+			}
+		}
+	}
+	var missing []string
+	for _, node := range nodes {
+		if !registered[node] {
+			missing = append(missing, node)
+		}
+	}
+	return missing, nil
+}
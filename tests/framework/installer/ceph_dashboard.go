@@ -0,0 +1,197 @@
+/*
+Copyright 2019 The Rook Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package installer
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rook/rook/tests/framework/utils"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	dashboardServiceName         = "rook-ceph-mgr-dashboard"
+	externalDashboardServiceName = "rook-ceph-mgr-dashboard-external"
+	// the port of the dashboard when served with ssl
+	dashboardPortHTTPS = 8443
+	tlsDialTimeout     = 10 * time.Second
+)
+
+// GetDashboardEndpoint returns the host:port where the dashboard can be reached from the tests. When the tests are not
+// running inside the cluster, a node port service is created to expose the dashboard.
+func (h *CephInstaller) GetDashboardEndpoint(namespace string) (string, error) {
+	svc, err := h.k8shelper.GetService(dashboardServiceName, namespace)
+	if err != nil {
+		return "", fmt.Errorf("dashboard service not found. %+v", err)
+	}
+	port := svc.Spec.Ports[0].Port
+	if h.k8shelper.RunningInCluster {
+		return fmt.Sprintf("%s:%d", svc.Spec.ClusterIP, port), nil
+	}
+
+	if err := h.createExternalDashboardService(namespace, port); err != nil {
+		return "", err
+	}
+	hostIP, err := h.k8shelper.GetPodHostID("rook-ceph-mgr", namespace)
+	if err != nil {
+		return "", fmt.Errorf("mgr pod not found. %+v", err)
+	}
+	nodePort, err := h.k8shelper.GetServiceNodePort(externalDashboardServiceName, namespace)
+	if err != nil {
+		return "", fmt.Errorf("external dashboard service not found. %+v", err)
+	}
+	return hostIP + ":" + nodePort, nil
+}
+
+func (h *CephInstaller) createExternalDashboardService(namespace string, port int32) error {
+	externalSvc := renderExternalDashboardService(namespace, string(v1.ServiceTypeNodePort), int(port))
+	_, err := h.k8shelper.KubectlWithStdin(externalSvc, createFromStdinArgs...)
+	if err != nil && !strings.Contains(err.Error(), "AlreadyExists") {
+		return fmt.Errorf("failed to create external dashboard service. %+v", err)
+	}
+	return nil
+}
+
+// renderExternalDashboardService returns the service of the given type that exposes the dashboard port of the mgr
+func renderExternalDashboardService(namespace, serviceType string, port int) string {
+	return `apiVersion: v1
+kind: Service
+metadata:
+  name: ` + externalDashboardServiceName + `
+  namespace: ` + namespace + `
+spec:
+  ports:
+  - name: dashboard
+    port: ` + strconv.Itoa(port) + `
+    protocol: TCP
+  selector:
+    app: rook-ceph-mgr
+    rook_cluster: ` + namespace + `
+  type: ` + serviceType + `
+`
+}
+
+// GetExternalDashboardURL returns the https url of the dashboard through the external dashboard service rendered with
+// the cluster. The address of a LoadBalancer service is awaited, and the node port of the service is used if the
+// load balancer is still pending, such as in clusters without a load balancer provider.
+func (h *CephInstaller) GetExternalDashboardURL(namespace string) (string, error) {
+	var svc *v1.Service
+	var err error
+	for i := 0; i < utils.RetryLoop; i++ {
+		svc, err = h.k8shelper.Clientset.CoreV1().Services(namespace).Get(externalDashboardServiceName, metav1.GetOptions{})
+		if err != nil {
+			return "", fmt.Errorf("external dashboard service not found. %+v", err)
+		}
+		if svc.Spec.Type != v1.ServiceTypeLoadBalancer {
+			break
+		}
+		if address := loadBalancerAddress(svc); address != "" {
+			return fmt.Sprintf("https://%s:%d", address, svc.Spec.Ports[0].Port), nil
+		}
+		logger.Infof("waiting for the load balancer of the dashboard service")
+		time.Sleep(utils.RetryInterval * time.Second)
+	}
+	if svc.Spec.Type == v1.ServiceTypeLoadBalancer {
+		logger.Warningf("the load balancer of the dashboard service is pending, falling back to its node port")
+	}
+
+	if svc.Spec.Ports[0].NodePort == 0 {
+		return "", fmt.Errorf("dashboard service %s of type %s has no node port", externalDashboardServiceName, svc.Spec.Type)
+	}
+	hostIP, err := h.k8shelper.GetPodHostID("rook-ceph-mgr", namespace)
+	if err != nil {
+		return "", fmt.Errorf("mgr pod not found. %+v", err)
+	}
+	return fmt.Sprintf("https://%s:%d", hostIP, svc.Spec.Ports[0].NodePort), nil
+}
+
+// loadBalancerAddress returns the ip or hostname assigned to the load balancer of the service, or an empty string if
+// the load balancer is pending
+func loadBalancerAddress(svc *v1.Service) string {
+	for _, ingress := range svc.Status.LoadBalancer.Ingress {
+		if ingress.IP != "" {
+			return ingress.IP
+		}
+		if ingress.Hostname != "" {
+			return ingress.Hostname
+		}
+	}
+	return ""
+}
+
+// VerifyDashboardTLS confirms the dashboard serves https. If the expected fingerprint is not empty, the sha256 fingerprint
+// of the dashboard certificate must match it. The fingerprint is compared without colons and case insensitive.
+func (h *CephInstaller) VerifyDashboardTLS(namespace, expectedFingerprint string) error {
+	endpoint, err := h.GetDashboardEndpoint(namespace)
+	if err != nil {
+		return err
+	}
+	if err := verifyTLSEndpoint(endpoint, expectedFingerprint); err != nil {
+		return fmt.Errorf("dashboard in namespace %s failed tls validation. %+v", namespace, err)
+	}
+	logger.Infof("dashboard at %s is serving https", endpoint)
+	return nil
+}
+
+// verifyTLSEndpoint performs a tls handshake with the endpoint and optionally checks the fingerprint of its certificate.
+// The certificate chain is not verified since the dashboard certificates are usually self-signed.
+func verifyTLSEndpoint(endpoint, expectedFingerprint string) error {
+	dialer := &net.Dialer{Timeout: tlsDialTimeout}
+	conn, err := tls.DialWithDialer(dialer, "tcp", endpoint, &tls.Config{InsecureSkipVerify: true})
+	if err != nil {
+		if _, ok := err.(tls.RecordHeaderError); ok {
+			return fmt.Errorf("%s is serving plain http instead of https", endpoint)
+		}
+		return fmt.Errorf("failed tls handshake with %s. %+v", endpoint, err)
+	}
+	defer conn.Close()
+
+	certs := conn.ConnectionState().PeerCertificates
+	if len(certs) == 0 {
+		return fmt.Errorf("%s did not present a certificate", endpoint)
+	}
+	if expectedFingerprint == "" {
+		return nil
+	}
+	actual := certFingerprint(certs[0])
+	if normalizeFingerprint(expectedFingerprint) != normalizeFingerprint(actual) {
+		return fmt.Errorf("certificate fingerprint of %s is %s, expected %s", endpoint, actual, expectedFingerprint)
+	}
+	return nil
+}
+
+// certFingerprint returns the sha256 fingerprint of the certificate in the colon separated format of openssl
+func certFingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	parts := make([]string, len(sum))
+	for i, b := range sum {
+		parts[i] = fmt.Sprintf("%02X", b)
+	}
+	return strings.Join(parts, ":")
+}
+
+func normalizeFingerprint(fingerprint string) string {
+	return strings.ToLower(strings.Replace(fingerprint, ":", "", -1))
+}
@@ -0,0 +1,55 @@
+/*
+Copyright 2019 The Rook Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package installer
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVerifyTLSEndpoint(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	server := httptest.NewTLSServer(handler)
+	defer server.Close()
+	endpoint := strings.TrimPrefix(server.URL, "https://")
+
+	// the handshake succeeds without checking the certificate
+	assert.Nil(t, verifyTLSEndpoint(endpoint, ""))
+
+	// the fingerprint matches regardless of the format
+	fingerprint := certFingerprint(server.Certificate())
+	assert.Nil(t, verifyTLSEndpoint(endpoint, fingerprint))
+	assert.Nil(t, verifyTLSEndpoint(endpoint, strings.ToLower(strings.Replace(fingerprint, ":", "", -1))))
+
+	// a different certificate is rejected
+	err := verifyTLSEndpoint(endpoint, "00:11:22")
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "expected 00:11:22")
+}
+
+func TestVerifyTLSEndpointPlainHTTP(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	err := verifyTLSEndpoint(strings.TrimPrefix(server.URL, "http://"), "")
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "plain http")
+}
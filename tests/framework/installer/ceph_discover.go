@@ -0,0 +1,106 @@
+/*
+Copyright 2019 The Rook Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package installer
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	discoverDaemon "github.com/rook/rook/pkg/daemon/discover"
+	"github.com/rook/rook/pkg/operator/k8sutil"
+	"github.com/rook/rook/tests/framework/utils"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// the discover interval of the operator if the installer does not set one
+	defaultDiscoverDevicesInterval = 60 * time.Minute
+)
+
+// Device is a device of a node found by the discover daemon
+type Device struct {
+	Name string `json:"name"`
+	// Size is the capacity in bytes
+	Size        uint64 `json:"size"`
+	Type        string `json:"type"`
+	Rotational  bool   `json:"rotational"`
+	Filesystem  string `json:"filesystem"`
+	HasChildren bool   `json:"hasChildren"`
+	Empty       bool   `json:"empty"`
+}
+
+// GetDiscoveredDevices returns the devices of the node in the configmap of the discover daemon. The configmap is
+// looked up in all namespaces since the discover daemons run in the namespace of the operator. An error is returned if
+// the discover daemon did not report the devices of the node yet.
+func (h *CephInstaller) GetDiscoveredDevices(nodeName string) ([]Device, error) {
+	selector := fmt.Sprintf("%s=%s,%s=%s", k8sutil.AppAttr, discoverDaemon.AppName, discoverDaemon.NodeAttr, nodeName)
+	cms, err := h.k8shelper.Clientset.CoreV1().ConfigMaps("").List(metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the discover configmaps. %+v", err)
+	}
+	if len(cms.Items) == 0 {
+		return nil, fmt.Errorf("discover daemon has not reported the devices of node %s. is the discover daemon running?", nodeName)
+	}
+	devices, err := parseDiscoveredDevices(cms.Items[0].Data[discoverDaemon.LocalDiskCMData])
+	if err != nil {
+		return nil, fmt.Errorf("invalid devices of node %s in configmap %s. %+v", nodeName, cms.Items[0].Name, err)
+	}
+	logger.Infof("discovered %d devices on node %s", len(devices), nodeName)
+	return devices, nil
+}
+
+func parseDiscoveredDevices(data string) ([]Device, error) {
+	if data == "" {
+		return nil, fmt.Errorf("no devices reported")
+	}
+	var devices []Device
+	if err := json.Unmarshal([]byte(data), &devices); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal devices: %s. %+v", data, err)
+	}
+	return devices, nil
+}
+
+// WaitForDiscoveredDevice waits for the discover daemon to report the device of the node, such as a device that was
+// hot-added to the node. The device must be reported within the discover interval of the operator, with an extra
+// retry interval for the discover daemon to update its configmap. The time until the device was reported is returned.
+func (h *CephInstaller) WaitForDiscoveredDevice(nodeName, device string) (time.Duration, error) {
+	interval := h.DiscoverDevicesInterval
+	if interval <= 0 {
+		interval = defaultDiscoverDevicesInterval
+	}
+	timeout := interval + utils.RetryInterval*time.Second
+	start := time.Now()
+	for {
+		devices, err := h.GetDiscoveredDevices(nodeName)
+		if err == nil {
+			for _, d := range devices {
+				if d.Name == device {
+					elapsed := time.Since(start)
+					logger.Infof("device %s of node %s was discovered after %v", device, nodeName, elapsed)
+					return elapsed, nil
+				}
+			}
+			err = fmt.Errorf("device not in the %d discovered devices", len(devices))
+		}
+		if time.Since(start) > timeout {
+			return 0, fmt.Errorf("device %s of node %s was not discovered within the discover interval %v. %v", device, nodeName, interval, err)
+		}
+		logger.Infof("waiting for device %s of node %s to be discovered. %v", device, nodeName, err)
+		time.Sleep(utils.RetryInterval * time.Second)
+	}
+}
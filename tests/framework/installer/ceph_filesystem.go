@@ -0,0 +1,68 @@
+/*
+Copyright 2019 The Rook Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package installer
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// fsStatus is the part of the "ceph fs status" response that lists the mds daemons of the filesystem
+type fsStatus struct {
+	MDSMap []struct {
+		Name  string `json:"name"`
+		Rank  int    `json:"rank"`
+		State string `json:"state"`
+	} `json:"mdsmap"`
+}
+
+// VerifyMDSStandbyReplay confirms with "ceph fs status" that the filesystem has the expected number of active mds
+// and a standby-replay mds for each active rank, as configured by activeCount and activeStandby in the filesystem
+// CR. The mds map is returned in the error on mismatch.
+func (h *CephInstaller) VerifyMDSStandbyReplay(namespace, fsName string, expectedActive int) error {
+	output, err := h.execCephCommand(namespace, "fs", "status", fsName)
+	if err != nil {
+		return fmt.Errorf("failed to get the status of filesystem %s. %+v", fsName, err)
+	}
+	var status fsStatus
+	if err := json.Unmarshal(output, &status); err != nil {
+		return fmt.Errorf("failed to unmarshal the status of filesystem %s: %s. %+v", fsName, string(output), err)
+	}
+	if err := status.verifyStandbyReplay(expectedActive); err != nil {
+		return fmt.Errorf("filesystem %s: %+v", fsName, err)
+	}
+	logger.Infof("filesystem %s has %d active and %d standby-replay mds", fsName, expectedActive, expectedActive)
+	return nil
+}
+
+func (s *fsStatus) verifyStandbyReplay(expectedActive int) error {
+	active := 0
+	standbyReplay := 0
+	for _, mds := range s.MDSMap {
+		switch mds.State {
+		case "active":
+			active++
+		case "standby-replay":
+			standbyReplay++
+		}
+	}
+	if active != expectedActive || standbyReplay != expectedActive {
+		return fmt.Errorf("expected %d active and %d standby-replay mds, found %d active and %d standby-replay. mds map: %+v",
+			expectedActive, expectedActive, active, standbyReplay, s.MDSMap)
+	}
+	return nil
+}
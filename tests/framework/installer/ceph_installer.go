@@ -17,6 +17,7 @@ limitations under the License.
 package installer
 
 import (
+	"encoding/json"
 	"flag"
 	"fmt"
 	"io/ioutil"
@@ -27,6 +28,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/ghodss/yaml"
 	cephv1 "github.com/rook/rook/pkg/apis/ceph.rook.io/v1"
 	"github.com/rook/rook/pkg/daemon/ceph/client"
 	opspec "github.com/rook/rook/pkg/operator/ceph/spec"
@@ -45,6 +47,8 @@ const (
 	mimicTestImage = "ceph/ceph:v13"
 	helmChartName  = "local/rook-ceph"
 	helmDeployName = "rook-ceph"
+	// the base dir of the dataDirHostPath when the data dir is on tmpfs, mounted in memory on most linux nodes
+	tmpfsTestDir = "/dev/shm"
 )
 
 var (
@@ -57,11 +61,48 @@ type CephInstaller struct {
 	Manifests        CephManifests
 	k8shelper        *utils.K8sHelper
 	hostPathToDelete string
+	// the data dir on the hosts of each cluster, keyed by the cluster namespace
+	dataDirHostPaths map[string]string
 	helmHelper       *utils.HelmHelper
 	k8sVersion       string
 	changeHostnames  bool
+	csiSupported     bool
 	cephVersion      cephv1.CephVersionSpec
 	T                func() *testing.T
+	// EnableRBDDriver and EnableCephFSDriver set which csi drivers the operator starts. Both are enabled by default.
+	EnableRBDDriver    bool
+	EnableCephFSDriver bool
+	// DiscoverDevicesInterval is how often the discover daemons look for new devices. The operator default is used if
+	// not set.
+	DiscoverDevicesInterval time.Duration
+	// OSDPrepareTimeout is how long the install waits for the osd prepare jobs. DefaultOSDPrepareTimeout is used if not set.
+	OSDPrepareTimeout time.Duration
+	// Cluster are the options of the CephCluster CR of the clusters created by the installer. The namespace, store
+	// type, dataDirHostPath, devices, mons, rbd mirrors and ceph version are set when each cluster is created. Nodes
+	// run the osds when the cluster does not start with all nodes, all the nodes are used if empty. NodeLocations are
+	// merged with the locations from the FailureDomainLabel.
+	Cluster ClusterSettings
+	// the ceph auth entities present after the install of each cluster namespace, used to find leaked entities
+	authBaseline map[string][]string
+	// FailureDomainLabel is the node label, such as topology.kubernetes.io/zone, whose value is added to the crush
+	// location of each storage node. The cluster must not start with all nodes for the locations to be rendered.
+	FailureDomainLabel string
+	// ToolboxLabels and ToolboxAnnotations are set on the toolbox pod, for example to satisfy admission policies
+	ToolboxLabels      map[string]string
+	ToolboxAnnotations map[string]string
+	// ToolboxServiceAccount runs the toolbox with the service account. The account is created with the toolbox if
+	// CreateToolboxServiceAccount is set, otherwise it must exist.
+	ToolboxServiceAccount       string
+	CreateToolboxServiceAccount bool
+	// NamespaceLabels are set on the namespace created for the cluster, for example to satisfy pod security admission
+	NamespaceLabels map[string]string
+	// ImagePullSecrets are rendered in the operator manifest to pull the operator image from a private registry. The
+	// secrets must exist in the operator namespace, see CreateImagePullSecret.
+	ImagePullSecrets []string
+	// DataDirOnTmpfs places the dataDirHostPath of the clusters under the tmpfs mount of the nodes to speed up the
+	// tests. The mon stores and osd metadata are lost if a node reboots and the data consumes the memory of the node,
+	// so this is only intended for short lived clusters. See VerifyDataDirOnTmpfs to confirm the dir is on tmpfs.
+	DataDirOnTmpfs bool
 }
 
 func (h *CephInstaller) CreateCephCRDs() error {
@@ -123,7 +164,7 @@ func (h *CephInstaller) CreateCephOperator(namespace string) (err error) {
 		h.k8shelper.ChangeHostnames()
 	}
 
-	rookOperator := h.Manifests.GetRookOperator(namespace)
+	rookOperator := h.Manifests.GetRookOperator(h.operatorSettings(namespace))
 
 	_, err = h.k8shelper.KubectlWithStdin(rookOperator, createFromStdinArgs...)
 	if err != nil {
@@ -135,6 +176,41 @@ func (h *CephInstaller) CreateCephOperator(namespace string) (err error) {
 	return nil
 }
 
+func (h *CephInstaller) operatorSettings(namespace string) *OperatorSettings {
+	return &OperatorSettings{
+		Namespace:               namespace,
+		EnableRBDDriver:         h.EnableRBDDriver,
+		EnableCephFSDriver:      h.EnableCephFSDriver,
+		DiscoverDevicesInterval: h.DiscoverDevicesInterval,
+		ImagePullSecrets:        h.ImagePullSecrets,
+	}
+}
+
+// waitForCSIDrivers waits for the plugins of the enabled csi drivers to run and confirms the plugins of the disabled
+// drivers are not started
+func (h *CephInstaller) waitForCSIDrivers(namespace string) error {
+	if !h.csiSupported {
+		return nil
+	}
+	drivers := []struct {
+		label   string
+		enabled bool
+	}{
+		{"app=csi-rbdplugin", h.EnableRBDDriver},
+		{"app=csi-cephfsplugin", h.EnableCephFSDriver},
+	}
+	for _, driver := range drivers {
+		if driver.enabled {
+			if err := h.k8shelper.WaitForLabeledPodsToRun(driver.label, namespace); err != nil {
+				return fmt.Errorf("csi driver pods with label %s are not running. %+v", driver.label, err)
+			}
+		} else if h.k8shelper.IsPodWithLabelPresent(driver.label, namespace) {
+			return fmt.Errorf("found csi driver pods with label %s although the driver is disabled", driver.label)
+		}
+	}
+	return nil
+}
+
 // CreateK8sRookOperatorViaHelm creates rook operator via Helm chart named local/rook present in local repo
 func (h *CephInstaller) CreateK8sRookOperatorViaHelm(namespace string) error {
 	// creating clusterrolebinding for kubeadm env.
@@ -159,7 +235,19 @@ func (h *CephInstaller) CreateK8sRookOperatorViaHelm(namespace string) error {
 func (h *CephInstaller) CreateK8sRookToolbox(namespace string) (err error) {
 	logger.Infof("Starting Rook toolbox")
 
-	rookToolbox := h.Manifests.GetRookToolBox(namespace)
+	if h.ToolboxServiceAccount != "" && h.CreateToolboxServiceAccount {
+		account := renderToolboxServiceAccount(namespace, h.ToolboxServiceAccount)
+		if _, err := h.k8shelper.KubectlWithStdin(account, createFromStdinArgs...); err != nil {
+			return fmt.Errorf("failed to create the toolbox service account %s. %+v", h.ToolboxServiceAccount, err)
+		}
+	}
+
+	rookToolbox := h.Manifests.GetRookToolBox(&ToolboxSettings{
+		Namespace:      namespace,
+		Labels:         h.ToolboxLabels,
+		Annotations:    h.ToolboxAnnotations,
+		ServiceAccount: h.ToolboxServiceAccount,
+	})
 
 	_, err = h.k8shelper.KubectlWithStdin(rookToolbox, createFromStdinArgs...)
 
@@ -182,6 +270,25 @@ func (h *CephInstaller) CreateK8sRookCluster(namespace, systemNamespace string,
 		LuminousVersion)
 }
 
+func newNamespace(name string, labels map[string]string) *v1.Namespace {
+	return &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels}}
+}
+
+// CreateImagePullSecret creates the docker registry secret with the credentials of the private registry in the
+// namespace, which is created if it does not exist. An existing secret is left unchanged.
+func (h *CephInstaller) CreateImagePullSecret(namespace, name, server, username, password string) error {
+	_, err := h.k8shelper.Clientset.CoreV1().Namespaces().Create(newNamespace(namespace, h.NamespaceLabels))
+	if err != nil && !errors.IsAlreadyExists(err) {
+		return fmt.Errorf("failed to create namespace %s. %+v", namespace, err)
+	}
+	_, err = h.k8shelper.Kubectl("-n", namespace, "create", "secret", "docker-registry", name,
+		"--docker-server="+server, "--docker-username="+username, "--docker-password="+password)
+	if err != nil && !strings.Contains(err.Error(), "AlreadyExists") {
+		return fmt.Errorf("failed to create image pull secret %s. %+v", name, err)
+	}
+	return nil
+}
+
 // CreateK8sRookCluster creates rook cluster via kubectl
 func (h *CephInstaller) CreateK8sRookClusterWithHostPathAndDevices(namespace, systemNamespace, storeType string,
 	useAllDevices bool, mon cephv1.MonSpec, startWithAllNodes bool, rbdMirrorWorkers int, cephVersion cephv1.CephVersionSpec) error {
@@ -194,8 +301,7 @@ func (h *CephInstaller) CreateK8sRookClusterWithHostPathAndDevices(namespace, sy
 		namespace, systemNamespace, storeType, dataDirHostPath, useAllDevices, startWithAllNodes, mon)
 
 	logger.Infof("Creating namespace %s", namespace)
-	ns := &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: namespace}}
-	_, err = h.k8shelper.Clientset.CoreV1().Namespaces().Create(ns)
+	_, err = h.k8shelper.Clientset.CoreV1().Namespaces().Create(newNamespace(namespace, h.NamespaceLabels))
 	if err != nil && !errors.IsAlreadyExists(err) {
 		return fmt.Errorf("failed to create namespace %s. %+v", namespace, err)
 	}
@@ -206,9 +312,33 @@ func (h *CephInstaller) CreateK8sRookClusterWithHostPathAndDevices(namespace, sy
 		return fmt.Errorf("Failed to create cluster roles. %+v", err)
 	}
 
+	var storageNodes []string
+	if !startWithAllNodes {
+		if storageNodes, err = h.storageNodes(); err != nil {
+			return err
+		}
+		logger.Infof("starting the cluster with osds on nodes %v", storageNodes)
+	}
+	nodeLocations, err := h.storageNodeLocations()
+	if err != nil {
+		return err
+	}
+
 	logger.Infof("Starting Rook Cluster with yaml")
-	settings := &ClusterSettings{namespace, storeType, dataDirHostPath, useAllDevices, mon.Count, rbdMirrorWorkers, cephVersion}
-	rookCluster := h.Manifests.GetRookCluster(settings)
+	settings := h.Cluster
+	settings.Namespace = namespace
+	settings.StoreType = storeType
+	settings.DataDirHostPath = dataDirHostPath
+	settings.UseAllDevices = useAllDevices
+	settings.Mons = mon.Count
+	settings.RBDMirrorWorkers = rbdMirrorWorkers
+	settings.CephVersion = cephVersion
+	settings.Nodes = storageNodes
+	settings.NodeLocations = nodeLocations
+	if err := h.verifyClusterAPIVersionServed(settings.clusterAPIVersion()); err != nil {
+		return err
+	}
+	rookCluster := h.Manifests.GetRookCluster(&settings)
 	if _, err := h.k8shelper.KubectlWithStdin(rookCluster, createFromStdinArgs...); err != nil {
 		return fmt.Errorf("Failed to create rook cluster : %v ", err)
 	}
@@ -217,7 +347,11 @@ func (h *CephInstaller) CreateK8sRookClusterWithHostPathAndDevices(namespace, sy
 		return err
 	}
 
-	if err := h.k8shelper.WaitForPodCount("app=rook-ceph-osd", namespace, 1); err != nil {
+	if settings.SkipOSDCreation {
+		if err := h.VerifyNoOSDsCreated(namespace); err != nil {
+			return err
+		}
+	} else if err := h.waitForOSDs(namespace, storageNodes); err != nil {
 		return err
 	}
 
@@ -228,15 +362,253 @@ func (h *CephInstaller) CreateK8sRookClusterWithHostPathAndDevices(namespace, sy
 	}
 
 	logger.Infof("Rook Cluster started")
-	err = h.k8shelper.WaitForLabeledPodsToRun("app=rook-ceph-osd", namespace)
-	return err
+	return nil
+}
+
+// CreateClusterExpectingRejection creates a cluster with invalid settings, such as a zero mon count, and confirms the
+// cluster is rejected. The cluster is rejected either when the validation of the CRD fails the create, such as the
+// minimum of one mon, or when the operator sets the cluster in the error state instead of starting the mons. The
+// namespace and the cluster roles must already exist. The cluster CR is removed before returning.
+func (h *CephInstaller) CreateClusterExpectingRejection(settings *ClusterSettings) error {
+	namespace := settings.Namespace
+	if _, err := h.k8shelper.KubectlWithStdin(h.Manifests.GetRookCluster(settings), createFromStdinArgs...); err != nil {
+		if isValidationError(err) {
+			logger.Infof("the create of cluster %s was rejected by the crd validation. %v", namespace, err)
+			return nil
+		}
+		return fmt.Errorf("failed to create the invalid cluster %s. %+v", namespace, err)
+	}
+	defer func() {
+		if _, err := h.k8shelper.DeleteResourceAndWait(false, "-n", namespace, "cephcluster", namespace); err != nil {
+			logger.Warningf("failed to remove the invalid cluster %s. %+v", namespace, err)
+		}
+	}()
+
+	var status cephv1.ClusterStatus
+	for i := 0; i < utils.RetryLoop; i++ {
+		cluster, err := h.k8shelper.RookClientset.CephV1().CephClusters(namespace).Get(namespace, metav1.GetOptions{})
+		if err != nil {
+			return fmt.Errorf("failed to get cluster %s. %+v", namespace, err)
+		}
+		status = cluster.Status
+		if status.State == cephv1.ClusterStateError {
+			logger.Infof("the operator rejected cluster %s: %s", namespace, status.Message)
+			return nil
+		}
+		mons, err := h.k8shelper.Clientset.CoreV1().Pods(namespace).List(metav1.ListOptions{LabelSelector: monLabel})
+		if err != nil {
+			return fmt.Errorf("failed to list the mons of cluster %s. %+v", namespace, err)
+		}
+		if status.State == cephv1.ClusterStateCreated || len(mons.Items) > 0 {
+			return fmt.Errorf("the operator accepted the invalid cluster %s with %d mons. state=%q, message=%q",
+				namespace, len(mons.Items), status.State, status.Message)
+		}
+		time.Sleep(utils.RetryInterval * time.Second)
+	}
+	return fmt.Errorf("gave up waiting for the operator to reject cluster %s. state=%q, message=%q", namespace, status.State, status.Message)
+}
+
+// isValidationError returns whether the error of kubectl create is caused by the api server rejecting the resource,
+// such as a value below the minimum of the openAPIV3Schema of the CRD
+func isValidationError(err error) bool {
+	return strings.Contains(err.Error(), " is invalid: ")
+}
+
+// verifyClusterAPIVersionServed confirms the installed cluster CRD serves the given apiVersion (group/version)
+func (h *CephInstaller) verifyClusterAPIVersionServed(apiVersion string) error {
+	output, err := h.k8shelper.GetResource("crd", cephClusterCRDName, "-o", "json")
+	if err != nil {
+		return fmt.Errorf("failed to get crd %s. %+v", cephClusterCRDName, err)
+	}
+	served, err := servedCRDVersions([]byte(output))
+	if err != nil {
+		return fmt.Errorf("failed to parse crd %s. %+v", cephClusterCRDName, err)
+	}
+	for _, version := range served {
+		if version == apiVersion {
+			return nil
+		}
+	}
+	return fmt.Errorf("cluster apiVersion %s is not served by crd %s. served versions: %v", apiVersion, cephClusterCRDName, served)
+}
+
+// servedCRDVersions returns the group/version strings served by the CRD in the json output
+func servedCRDVersions(crdJSON []byte) ([]string, error) {
+	var crd struct {
+		Spec struct {
+			Group    string `json:"group"`
+			Version  string `json:"version"`
+			Versions []struct {
+				Name   string `json:"name"`
+				Served bool   `json:"served"`
+			} `json:"versions"`
+		} `json:"spec"`
+	}
+	if err := json.Unmarshal(crdJSON, &crd); err != nil {
+		return nil, err
+	}
+
+	var served []string
+	for _, v := range crd.Spec.Versions {
+		if v.Served {
+			served = append(served, fmt.Sprintf("%s/%s", crd.Spec.Group, v.Name))
+		}
+	}
+	if len(crd.Spec.Versions) == 0 && crd.Spec.Version != "" {
+		served = append(served, fmt.Sprintf("%s/%s", crd.Spec.Group, crd.Spec.Version))
+	}
+	return served, nil
+}
+
+// DumpInstalledCRDs writes the yaml of each installed rook CRD to the directory, one file per CRD named after the
+// CRD. This helps to find stale CRDs left behind by other versions of the tests.
+func (h *CephInstaller) DumpInstalledCRDs(dir string) error {
+	output, err := h.k8shelper.GetResource("crd", "-o", "json")
+	if err != nil {
+		return fmt.Errorf("failed to list crds. %+v", err)
+	}
+	files, err := writeRookCRDs(dir, []byte(output))
+	if err != nil {
+		return err
+	}
+	logger.Infof("dumped %d crds to %s", len(files), dir)
+	return nil
+}
+
+// writeRookCRDs writes the rook CRDs found in the json list of CRDs as yaml files in the directory and returns the
+// paths of the files
+func writeRookCRDs(dir string, crdListJSON []byte) ([]string, error) {
+	var list struct {
+		Items []json.RawMessage `json:"items"`
+	}
+	if err := json.Unmarshal(crdListJSON, &list); err != nil {
+		return nil, fmt.Errorf("failed to parse the crd list. %+v", err)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create dir %s. %+v", dir, err)
+	}
+
+	var files []string
+	for _, item := range list.Items {
+		var crd struct {
+			Metadata struct {
+				Name string `json:"name"`
+			} `json:"metadata"`
+		}
+		if err := json.Unmarshal(item, &crd); err != nil {
+			return nil, fmt.Errorf("failed to parse crd. %+v", err)
+		}
+		if !strings.HasSuffix(crd.Metadata.Name, ".rook.io") {
+			continue
+		}
+		manifest, err := yaml.JSONToYAML(item)
+		if err != nil {
+			return nil, fmt.Errorf("failed to convert crd %s to yaml. %+v", crd.Metadata.Name, err)
+		}
+		file := path.Join(dir, crd.Metadata.Name+".yaml")
+		if err := ioutil.WriteFile(file, manifest, 0644); err != nil {
+			return nil, fmt.Errorf("failed to write crd %s. %+v", crd.Metadata.Name, err)
+		}
+		files = append(files, file)
+	}
+	return files, nil
+}
+
+// VerifyConfigOverride confirms that a running daemon (for example mon.a) has the expected value of a setting with
+// "ceph config show". This requires mimic or newer.
+func (h *CephInstaller) VerifyConfigOverride(namespace, daemon, key, expected string) error {
+	actual, err := h.getRunningConfig(namespace, daemon, key)
+	if err != nil {
+		return err
+	}
+	if actual != expected {
+		return fmt.Errorf("%s of %s is %q instead of %q", key, daemon, actual, expected)
+	}
+	logger.Infof("%s of %s is %q", key, daemon, actual)
+	return nil
+}
+
+// getRunningConfig returns the value of the setting in the running config of the daemon from "ceph config show"
+func (h *CephInstaller) getRunningConfig(namespace, daemon, key string) (string, error) {
+	output, err := h.execCephCommand(namespace, "config", "show", daemon, key)
+	if err != nil {
+		return "", fmt.Errorf("failed to get %s of %s. %+v", key, daemon, err)
+	}
+	return parseConfigValue(output), nil
+}
+
+// parseConfigValue returns the value of a setting in the output of "ceph config", which is a json string when json
+// output is requested
+func parseConfigValue(output []byte) string {
+	var value string
+	if err := json.Unmarshal(output, &value); err == nil {
+		return value
+	}
+	return strings.TrimSpace(string(output))
+}
+
+// VerifyRecoveryThrottle confirms that every osd runs with the backfill and recovery limits of the throttle. The
+// running config is read with "ceph config show" since the settings of the config overrides are not stored in the
+// config database of the mons that "ceph config get" reads.
+func (h *CephInstaller) VerifyRecoveryThrottle(namespace string, throttle *RecoveryThrottle) error {
+	ids, err := h.GetOSDIDs(namespace)
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		for key, expected := range throttle.settings() {
+			if err := h.VerifyConfigOverride(namespace, fmt.Sprintf("osd.%d", id), key, expected); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// GetClusterFSID returns the fsid of the cluster as reported by "ceph fsid". If ceph cannot be reached, the fsid is
+// read from the mon secret that the operator keeps for the cluster.
+func (h *CephInstaller) GetClusterFSID(namespace string) (string, error) {
+	output, cephErr := h.execCephCommand(namespace, "fsid")
+	if cephErr == nil {
+		var response struct {
+			FSID string `json:"fsid"`
+		}
+		if err := json.Unmarshal(output, &response); err == nil && response.FSID != "" {
+			return response.FSID, nil
+		}
+		cephErr = fmt.Errorf("unexpected fsid response: %s", string(output))
+	}
+
+	secret, err := h.k8shelper.Clientset.CoreV1().Secrets(namespace).Get("rook-ceph-mon", metav1.GetOptions{})
+	if err != nil {
+		return "", fmt.Errorf("failed to determine the fsid of cluster %s. ceph: %+v. mon secret: %+v", namespace, cephErr, err)
+	}
+	fsid := string(secret.Data["fsid"])
+	if fsid == "" {
+		return "", fmt.Errorf("failed to determine the fsid of cluster %s. ceph: %+v. the mon secret has no fsid", namespace, cephErr)
+	}
+	logger.Warningf("read the fsid from the mon secret since ceph did not report it. %+v", cephErr)
+	return fsid, nil
+}
+
+// execCephCommand runs a ceph command in the toolbox of the cluster and returns the json output
+func (h *CephInstaller) execCephCommand(namespace string, args ...string) ([]byte, error) {
+	return client.ExecuteCephCommand(h.k8shelper.MakeContext(), namespace, args)
+}
+
+func (h *CephInstaller) execRBDCommand(namespace string, args ...string) ([]byte, error) {
+	return client.ExecuteRBDCommand(h.k8shelper.MakeContext(), namespace, args)
 }
 
 func (h *CephInstaller) initTestDir(namespace string) (string, error) {
-	h.hostPathToDelete = path.Join(baseTestDir, "rook-test")
+	base := baseTestDir
+	if h.DataDirOnTmpfs {
+		base = tmpfsTestDir
+	}
+	h.hostPathToDelete = path.Join(base, "rook-test")
 	testDir := path.Join(h.hostPathToDelete, namespace)
 
-	if createBaseTestDir {
+	if createBaseTestDir && !h.DataDirOnTmpfs {
 		// Create the test dir on the local host
 		if err := os.MkdirAll(testDir, 0777); err != nil {
 			return "", err
@@ -251,9 +623,76 @@ func (h *CephInstaller) initTestDir(namespace string) (string, error) {
 		r := rand.Int()
 		testDir = path.Join(testDir, fmt.Sprintf("test-%d", r))
 	}
+	if h.dataDirHostPaths == nil {
+		h.dataDirHostPaths = map[string]string{}
+	}
+	h.dataDirHostPaths[namespace] = testDir
 	return testDir, nil
 }
 
+// DataDirHostPath returns the dataDirHostPath of the cluster in the namespace, or an empty string if the installer did
+// not create the cluster
+func (h *CephInstaller) DataDirHostPath(namespace string) string {
+	return h.dataDirHostPaths[namespace]
+}
+
+// VerifyDataDirOnTmpfs confirms the dataDirHostPath of the cluster is on a tmpfs filesystem on the node of a mon. The
+// filesystem type is read with "stat -f" in a pod on the node.
+func (h *CephInstaller) VerifyDataDirOnTmpfs(namespace string) error {
+	dataDir := h.DataDirHostPath(namespace)
+	if dataDir == "" {
+		return fmt.Errorf("no data dir found for cluster %s", namespace)
+	}
+	pods, err := h.k8shelper.Clientset.CoreV1().Pods(namespace).List(metav1.ListOptions{LabelSelector: "app=rook-ceph-mon"})
+	if err != nil {
+		return fmt.Errorf("failed to list mons. %+v", err)
+	}
+	if len(pods.Items) == 0 {
+		return fmt.Errorf("no mons found in namespace %s", namespace)
+	}
+
+	// the tmpfs dir is under /dev, which is always mounted from the host by the node command pod
+	nodeName := pods.Items[0].Spec.NodeName
+	output, err := h.runOnNode(namespace, nodeName, "rook-tmpfs-check", "stat", "-f", "-c", "%T", dataDir)
+	if err != nil {
+		return fmt.Errorf("failed to check the filesystem of %s on node %s. %+v", dataDir, nodeName, err)
+	}
+	if !isTmpfs(output) {
+		return fmt.Errorf("data dir %s on node %s is on filesystem %q instead of tmpfs", dataDir, nodeName, strings.TrimSpace(output))
+	}
+	logger.Infof("data dir %s of cluster %s is on tmpfs", dataDir, namespace)
+	return nil
+}
+
+// isTmpfs returns whether the filesystem type printed by stat is tmpfs
+func isTmpfs(statOutput string) bool {
+	return strings.TrimSpace(statOutput) == "tmpfs"
+}
+
+// hostPathsToClean returns the data dirs of the clusters in the namespaces and forgets them. The base test dir is only
+// included once no other cluster of the installer is left, so clusters that keep running are not affected.
+func (h *CephInstaller) hostPathsToClean(namespaces []string) []string {
+	var paths []string
+	for _, namespace := range namespaces {
+		if dir, ok := h.dataDirHostPaths[namespace]; ok {
+			paths = append(paths, dir)
+			delete(h.dataDirHostPaths, namespace)
+		}
+	}
+	if len(h.dataDirHostPaths) == 0 && h.hostPathToDelete != "" {
+		paths = append(paths, h.hostPathToDelete)
+	}
+	return paths
+}
+
+// storageNodes returns the nodes that run osds if the cluster does not start with all nodes
+func (h *CephInstaller) storageNodes() ([]string, error) {
+	if len(h.Cluster.Nodes) > 0 {
+		return h.Cluster.Nodes, nil
+	}
+	return h.GetNodeHostnames()
+}
+
 func (h *CephInstaller) GetNodeHostnames() ([]string, error) {
 	nodes, err := h.k8shelper.Clientset.CoreV1().Nodes().List(metav1.ListOptions{})
 	if err != nil {
@@ -299,12 +738,14 @@ func (h *CephInstaller) InstallRookOnK8sWithHostPathAndDevices(namespace, storeT
 			return false, err
 		}
 	}
-	if !h.k8shelper.IsPodInExpectedState("rook-ceph-operator", onamespace, "Running") {
-		logger.Error("rook-ceph-operator is not running")
-		h.k8shelper.GetRookLogs("rook-ceph-operator", Env.HostType, onamespace, "test-setup")
-		logger.Error("rook-ceph-operator is not Running, abort!")
+	if err := h.verifyOperatorRunning(onamespace); err != nil {
 		return false, err
 	}
+	if !helmInstalled {
+		if err := h.waitForCSIDrivers(onamespace); err != nil {
+			return false, err
+		}
+	}
 
 	if forceUseDevices {
 		logger.Infof("Forcing the use of devices")
@@ -331,15 +772,63 @@ func (h *CephInstaller) InstallRookOnK8sWithHostPathAndDevices(namespace, storeT
 		logger.Errorf("Rook toolbox in cluster %s not installed, error -> %v", namespace, err)
 		return false, err
 	}
+
+	if err := h.RecordAuthEntities(namespace); err != nil {
+		logger.Warningf("failed to record the auth entities of cluster %s. %+v", namespace, err)
+	}
 	logger.Infof("installed rook operator and cluster : %s on k8s %s", namespace, h.k8sVersion)
 	return true, nil
 }
 
+// InstallOperatorOnly installs the rook CRDs and the operator in the system namespace of the given namespace
+// without creating a cluster. The tests are expected to create their own CRs.
+func (h *CephInstaller) InstallOperatorOnly(namespace string) error {
+	// flag used for local debugging purpose, when rook is pre-installed
+	if Env.SkipInstallRook {
+		return nil
+	}
+
+	logger.Infof("Installing the rook operator on k8s %s without a cluster", h.k8shelper.GetK8sServerVersion())
+	onamespace := SystemNamespace(namespace)
+	if err := h.CreateCephOperator(onamespace); err != nil {
+		logger.Errorf("Rook Operator not installed ,error -> %v", err)
+		return err
+	}
+	if err := h.verifyOperatorRunning(onamespace); err != nil {
+		return err
+	}
+	if err := h.waitForCSIDrivers(onamespace); err != nil {
+		return err
+	}
+
+	logger.Infof("installed rook operator in namespace %s", onamespace)
+	return nil
+}
+
+func (h *CephInstaller) verifyOperatorRunning(namespace string) error {
+	if !h.k8shelper.IsPodInExpectedState("rook-ceph-operator", namespace, "Running") {
+		logger.Error("rook-ceph-operator is not running")
+		h.k8shelper.GetRookLogs("rook-ceph-operator", Env.HostType, namespace, "test-setup")
+		logger.Error("rook-ceph-operator is not Running, abort!")
+		return fmt.Errorf("rook-ceph-operator is not running in namespace %s", namespace)
+	}
+	return nil
+}
+
 // UninstallRookFromK8s uninstalls rook from k8s
 func (h *CephInstaller) UninstallRook(helmInstalled bool, namespace string) {
 	h.UninstallRookFromMultipleNS(helmInstalled, SystemNamespace(namespace), namespace)
 }
 
+// namespaceExists returns whether the namespace exists
+func namespaceExists(clientset kubernetes.Interface, namespace string) (bool, error) {
+	_, err := clientset.CoreV1().Namespaces().Get(namespace, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
 // UninstallRookFromK8s uninstalls rook from multiple namespaces in k8s
 func (h *CephInstaller) UninstallRookFromMultipleNS(helmInstalled bool, systemNamespace string, namespaces ...string) {
 	// flag used for local debugging purpose, when rook is pre-installed
@@ -353,16 +842,29 @@ func (h *CephInstaller) UninstallRookFromMultipleNS(helmInstalled bool, systemNa
 		roles := h.Manifests.GetClusterRoles(namespace, systemNamespace)
 		_, err = h.k8shelper.KubectlWithStdin(roles, deleteFromStdinArgs...)
 
-		_, err = h.k8shelper.DeleteResourceAndWait(false, "-n", namespace, "cephcluster", namespace)
-		checkError(h.T(), err, fmt.Sprintf("cannot remove cluster %s", namespace))
-
 		crdCheckerFunc := func() error {
 			_, err := h.k8shelper.RookClientset.CephV1().CephClusters(namespace).Get(namespace, metav1.GetOptions{})
 			return err
 		}
-		err = h.k8shelper.WaitForCustomResourceDeletion(namespace, crdCheckerFunc)
-		checkError(h.T(), err, fmt.Sprintf("failed to wait for crd %s deletion", namespace))
+		if err = crdCheckerFunc(); errors.IsNotFound(err) {
+			// the operator was installed without a cluster
+			logger.Infof("cluster %s not found, skipping its removal", namespace)
+		} else {
+			_, err = h.k8shelper.DeleteResourceAndWait(false, "-n", namespace, "cephcluster", namespace)
+			checkError(h.T(), err, fmt.Sprintf("cannot remove cluster %s", namespace))
+
+			err = h.k8shelper.WaitForCustomResourceDeletion(namespace, crdCheckerFunc)
+			checkError(h.T(), err, fmt.Sprintf("failed to wait for crd %s deletion", namespace))
+		}
 
+		// the namespace of the cluster is not created if only the operator was installed
+		var exists bool
+		exists, err = namespaceExists(h.k8shelper.Clientset, namespace)
+		checkError(h.T(), err, fmt.Sprintf("cannot get namespace %s", namespace))
+		if !exists {
+			logger.Infof("namespace %s not found, skipping its removal", namespace)
+			continue
+		}
 		_, err = h.k8shelper.DeleteResourceAndWait(false, "namespace", namespace)
 		checkError(h.T(), err, fmt.Sprintf("cannot delete namespace %s", namespace))
 	}
@@ -381,7 +883,7 @@ func (h *CephInstaller) UninstallRookFromMultipleNS(helmInstalled bool, systemNa
 	if helmInstalled {
 		err = h.helmHelper.DeleteLocalRookHelmChart(helmDeployName)
 	} else {
-		rookOperator := h.Manifests.GetRookOperator(systemNamespace)
+		rookOperator := h.Manifests.GetRookOperator(h.operatorSettings(systemNamespace))
 		_, err = h.k8shelper.KubectlWithStdin(rookOperator, deleteFromStdinArgs...)
 	}
 	checkError(h.T(), err, "cannot uninstall rook-operator")
@@ -410,14 +912,16 @@ func (h *CephInstaller) UninstallRookFromMultipleNS(helmInstalled bool, systemNa
 	h.k8shelper.Clientset.CoreV1().ConfigMaps(systemNamespace).Delete("csi-cephfs-config", nil)
 
 	logger.Infof("done removing the operator from namespace %s", systemNamespace)
-	logger.Infof("removing host data dir %s", h.hostPathToDelete)
-	// removing data dir if exists
-	if h.hostPathToDelete != "" {
+	// removing the data dirs of the clusters if they exist
+	if paths := h.hostPathsToClean(namespaces); len(paths) > 0 {
+		logger.Infof("removing host data dirs %v", paths)
 		nodes, err := h.GetNodeHostnames()
 		checkError(h.T(), err, "cannot get node names")
 		for _, node := range nodes {
-			err = h.cleanupDir(node, h.hostPathToDelete)
-			logger.Infof("removing %s from node %s. err=%v", h.hostPathToDelete, node, err)
+			for _, dir := range paths {
+				err = h.cleanupDir(node, dir)
+				logger.Infof("removing %s from node %s. err=%v", dir, node, err)
+			}
 		}
 	}
 	if h.changeHostnames {
@@ -468,13 +972,16 @@ func NewCephInstaller(t func() *testing.T, clientset *kubernetes.Clientset, rook
 	logger.Infof("Rook Version: %s", rookVersion)
 	logger.Infof("Ceph Version: %s (%s)", cephVersion.Image, cephVersion.Name)
 	h := &CephInstaller{
-		Manifests:       NewCephManifests(rookVersion),
-		k8shelper:       k8shelp,
-		helmHelper:      utils.NewHelmHelper(Env.Helm),
-		k8sVersion:      version.String(),
-		cephVersion:     cephVersion,
-		changeHostnames: rookVersion != Version0_9 && k8shelp.VersionAtLeast("v1.13.0"),
-		T:               t,
+		Manifests:          NewCephManifests(rookVersion),
+		k8shelper:          k8shelp,
+		helmHelper:         utils.NewHelmHelper(Env.Helm),
+		k8sVersion:         version.String(),
+		cephVersion:        cephVersion,
+		changeHostnames:    rookVersion != Version0_9 && k8shelp.VersionAtLeast("v1.13.0"),
+		csiSupported:       rookVersion != Version0_9 && k8shelp.VersionAtLeast("v1.13.0"),
+		EnableRBDDriver:    true,
+		EnableCephFSDriver: true,
+		T:                  t,
 	}
 	flag.Parse()
 	return h
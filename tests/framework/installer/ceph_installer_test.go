@@ -0,0 +1,777 @@
+/*
+Copyright 2019 The Rook Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package installer
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	cephv1 "github.com/rook/rook/pkg/apis/ceph.rook.io/v1"
+	rookalpha "github.com/rook/rook/pkg/apis/rook.io/v1alpha2"
+	"github.com/rook/rook/pkg/daemon/ceph/client"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	batch "k8s.io/api/batch/v1"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestIsValidationError(t *testing.T) {
+	assert.True(t, isValidationError(fmt.Errorf(`The CephCluster "rook-ceph" is invalid: spec.mon.count: Invalid value: 0: spec.mon.count in body should be greater than or equal to 1`)))
+	assert.False(t, isValidationError(fmt.Errorf(`Error from server (AlreadyExists): cephclusters.ceph.rook.io "rook-ceph" already exists`)))
+}
+
+func TestServedCRDVersions(t *testing.T) {
+	// a crd with a single version
+	served, err := servedCRDVersions([]byte(`{"spec":{"group":"ceph.rook.io","version":"v1"}}`))
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"ceph.rook.io/v1"}, served)
+
+	// only the served versions of a multi-version crd are returned
+	served, err = servedCRDVersions([]byte(`{"spec":{"group":"ceph.rook.io","version":"v1","versions":[
+		{"name":"v1","served":true},{"name":"v1beta1","served":false},{"name":"v2","served":true}]}}`))
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"ceph.rook.io/v1", "ceph.rook.io/v2"}, served)
+
+	_, err = servedCRDVersions([]byte(`not json`))
+	assert.NotNil(t, err)
+}
+
+func TestWriteRookCRDs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "crds")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	crdList := `{"kind":"List","items":[
+		{"kind":"CustomResourceDefinition","metadata":{"name":"cephclusters.ceph.rook.io"},"spec":{"group":"ceph.rook.io","version":"v1"}},
+		{"kind":"CustomResourceDefinition","metadata":{"name":"volumes.rook.io"},"spec":{"group":"rook.io","version":"v1alpha2"}},
+		{"kind":"CustomResourceDefinition","metadata":{"name":"certificates.example.com"},"spec":{"group":"example.com"}}]}`
+	files, err := writeRookCRDs(dir, []byte(crdList))
+	require.Nil(t, err)
+	assert.Equal(t, []string{path.Join(dir, "cephclusters.ceph.rook.io.yaml"), path.Join(dir, "volumes.rook.io.yaml")}, files)
+
+	manifest, err := ioutil.ReadFile(path.Join(dir, "cephclusters.ceph.rook.io.yaml"))
+	require.Nil(t, err)
+	assert.Contains(t, string(manifest), "name: cephclusters.ceph.rook.io")
+	assert.Contains(t, string(manifest), "group: ceph.rook.io")
+
+	_, err = os.Stat(path.Join(dir, "certificates.example.com.yaml"))
+	assert.True(t, os.IsNotExist(err))
+
+	_, err = writeRookCRDs(dir, []byte("not json"))
+	assert.NotNil(t, err)
+}
+
+func TestOSDPrepareTimeout(t *testing.T) {
+	h := &CephInstaller{}
+	assert.Equal(t, DefaultOSDPrepareTimeout, h.osdPrepareTimeout())
+
+	h.OSDPrepareTimeout = 30 * time.Minute
+	assert.Equal(t, 30*time.Minute, h.osdPrepareTimeout())
+}
+
+func TestOSDPrepareJobsComplete(t *testing.T) {
+	newJob := func(name string, active, succeeded, failed int32) batch.Job {
+		return batch.Job{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Status:     batch.JobStatus{Active: active, Succeeded: succeeded, Failed: failed},
+		}
+	}
+
+	done, status := osdPrepareJobsComplete(nil)
+	assert.False(t, done)
+	assert.Equal(t, "no osd prepare jobs found", status)
+
+	done, status = osdPrepareJobsComplete([]batch.Job{newJob("prepare-b", 1, 0, 1), newJob("prepare-a", 0, 1, 0)})
+	assert.False(t, done)
+	assert.Equal(t, "prepare-a(active=0, succeeded=1, failed=0), prepare-b(active=1, succeeded=0, failed=1)", status)
+
+	done, _ = osdPrepareJobsComplete([]batch.Job{newJob("prepare-a", 0, 1, 0), newJob("prepare-b", 0, 1, 1)})
+	assert.True(t, done)
+}
+
+func TestNamespaceExistsWithOperatorOnly(t *testing.T) {
+	// an operator only install creates the system namespace but not the namespace of the cluster
+	clientset := fake.NewSimpleClientset()
+	_, err := clientset.CoreV1().Namespaces().Create(newNamespace(SystemNamespace("rook-ceph"), nil))
+	require.Nil(t, err)
+
+	exists, err := namespaceExists(clientset, "rook-ceph")
+	assert.Nil(t, err)
+	assert.False(t, exists)
+
+	exists, err = namespaceExists(clientset, SystemNamespace("rook-ceph"))
+	assert.Nil(t, err)
+	assert.True(t, exists)
+}
+
+func TestNewNamespaceLabels(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	labels := map[string]string{"pod-security.kubernetes.io/enforce": "privileged"}
+	_, err := clientset.CoreV1().Namespaces().Create(newNamespace("rook-ceph", labels))
+	assert.Nil(t, err)
+
+	ns, err := clientset.CoreV1().Namespaces().Get("rook-ceph", metav1.GetOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, "privileged", ns.Labels["pod-security.kubernetes.io/enforce"])
+	assert.Equal(t, 1, len(ns.Labels))
+
+	// no labels are set by default
+	_, err = clientset.CoreV1().Namespaces().Create(newNamespace("rook-ceph-2", nil))
+	assert.Nil(t, err)
+	ns, err = clientset.CoreV1().Namespaces().Get("rook-ceph-2", metav1.GetOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(ns.Labels))
+}
+
+func TestOSDUsesDevice(t *testing.T) {
+	assert.True(t, osdUsesDevice(map[string]interface{}{"devices": "sda,sdb"}, "sdb"))
+	assert.True(t, osdUsesDevice(map[string]interface{}{"bluestore_bdev_dev_node": "/dev/sdb"}, "sdb"))
+	assert.True(t, osdUsesDevice(map[string]interface{}{"backend_filestore_dev_node": "sdc"}, "/dev/sdc"))
+	assert.False(t, osdUsesDevice(map[string]interface{}{"devices": "sda", "bluestore_bdev_dev_node": "/dev/sda"}, "sdb"))
+	assert.False(t, osdUsesDevice(map[string]interface{}{}, "sdb"))
+}
+
+func TestMonNodeDistribution(t *testing.T) {
+	newPod := func(name, node string) v1.Pod {
+		return v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: name}, Spec: v1.PodSpec{NodeName: node}}
+	}
+	distribution := monNodeDistribution([]v1.Pod{newPod("mon-c", "node2"), newPod("mon-a", "node1"), newPod("mon-b", "node2")})
+	assert.Equal(t, map[string][]string{"node1": {"mon-a"}, "node2": {"mon-c", "mon-b"}}, distribution)
+	assert.Equal(t, "node1=[mon-a], node2=[mon-b mon-c]", formatMonDistribution(distribution))
+}
+
+func TestCrushAncestors(t *testing.T) {
+	tree := []byte(`{"nodes":[{"id":-1,"name":"default","type":"root","children":[-2]},
+		{"id":-2,"name":"rack1","type":"rack","children":[-3]},
+		{"id":-3,"name":"node1","type":"host","children":[0]},
+		{"id":-4,"name":"node2","type":"host","children":[1]},
+		{"id":0,"name":"osd.0","type":"osd"},{"id":1,"name":"osd.1","type":"osd"}]}`)
+	ancestors, err := crushAncestors(tree, "node1")
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{"rack": "rack1", "root": "default"}, ancestors)
+
+	ancestors, err = crushAncestors(tree, "node2")
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(ancestors))
+
+	_, err = crushAncestors(tree, "node3")
+	assert.NotNil(t, err)
+}
+
+func TestNewOSDsUpAndIn(t *testing.T) {
+	dump := []byte(`{"epoch":12,"osds":[{"osd":0,"up":1,"in":1},{"osd":1,"up":1,"in":1},
+		{"osd":2,"up":0,"in":1},{"osd":3,"up":1,"in":1}]}`)
+	ids, err := newOSDsUpAndIn(dump, []int{0, 1})
+	assert.Nil(t, err)
+	assert.Equal(t, []int{3}, ids)
+
+	ids, err = newOSDsUpAndIn(dump, []int{0, 1, 3})
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(ids))
+
+	_, err = newOSDsUpAndIn([]byte("invalid"), nil)
+	assert.NotNil(t, err)
+}
+
+func TestMonInventoryIssues(t *testing.T) {
+	endpoints := "a=10.0.0.1:6789,b=10.0.0.2:6789,c=10.0.0.3:6789"
+	assert.Equal(t, 0, len(monInventoryIssues([]string{"c", "a", "b"}, endpoints)))
+
+	assert.Equal(t, []string{
+		"mon a has 2 deployments",
+		"mon d has a deployment but no endpoint",
+	}, monInventoryIssues([]string{"a", "a", "b", "c", "d"}, endpoints))
+
+	assert.Equal(t, []string{
+		"mon b has an endpoint but no deployment",
+		"mon c is listed 2 times in the endpoints",
+		"mons [b c] share the endpoint 10.0.0.2:6789",
+	}, monInventoryIssues([]string{"a", "c"}, "a=10.0.0.1:6789,b=10.0.0.2:6789,c=10.0.0.2:6789,c=10.0.0.3:6789"))
+
+	assert.Equal(t, []string{"invalid endpoint \"b\""}, monInventoryIssues([]string{"a"}, "a=10.0.0.1:6789,b"))
+}
+
+func TestOSDsWithWrongWeights(t *testing.T) {
+	df := []byte(`{"nodes":[{"id":-1,"name":"default","type":"root","crush_weight":0.039,"kb":41943040},
+		{"id":0,"name":"osd.0","type":"osd","crush_weight":0.0098,"kb":10485760},
+		{"id":1,"name":"osd.1","type":"osd","crush_weight":0.0195,"kb":20971520},
+		{"id":2,"name":"osd.2","type":"osd","crush_weight":0.0098,"kb":10485760}]}`)
+	wrong, err := osdsWithWrongWeights(df)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(wrong))
+
+	df = []byte(`{"nodes":[{"id":0,"name":"osd.0","type":"osd","crush_weight":0.0098,"kb":10485760},
+		{"id":1,"name":"osd.1","type":"osd","crush_weight":0.0098,"kb":20971520},
+		{"id":2,"name":"osd.2","type":"osd","crush_weight":0.0098,"kb":10485760},
+		{"id":3,"name":"osd.3","type":"osd","crush_weight":0,"kb":10485760}]}`)
+	wrong, err = osdsWithWrongWeights(df)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"osd.3(weight=0, kb=10485760)", "osd.1(weight=0.0098, kb=20971520)"}, wrong)
+
+	_, err = osdsWithWrongWeights([]byte("invalid"))
+	assert.NotNil(t, err)
+}
+
+func TestOSDInTree(t *testing.T) {
+	tree := []byte(`{"nodes":[{"id":-1,"name":"default","type":"root"},{"id":-3,"name":"node1","type":"host"},
+		{"id":0,"name":"osd.0","type":"osd"}],"stray":[{"id":2,"name":"osd.2"}]}`)
+	found, err := osdInTree(tree, 0)
+	assert.Nil(t, err)
+	assert.True(t, found)
+
+	found, err = osdInTree(tree, 2)
+	assert.Nil(t, err)
+	assert.True(t, found)
+
+	found, err = osdInTree(tree, 1)
+	assert.Nil(t, err)
+	assert.False(t, found)
+
+	_, err = osdInTree([]byte("not json"), 0)
+	assert.NotNil(t, err)
+}
+
+func TestMDSStandbyReplay(t *testing.T) {
+	var status fsStatus
+	output := `{"mdsmap":[{"name":"myfs-a","rank":0,"state":"active"},{"name":"myfs-b","rank":0,"state":"standby-replay"},
+		{"name":"myfs-c","rank":1,"state":"active"},{"name":"myfs-d","rank":1,"state":"standby-replay"}]}`
+	require.Nil(t, json.Unmarshal([]byte(output), &status))
+	assert.Nil(t, status.verifyStandbyReplay(2))
+	assert.NotNil(t, status.verifyStandbyReplay(1))
+
+	// a standby that is not replaying does not count
+	output = `{"mdsmap":[{"name":"myfs-a","rank":0,"state":"active"},{"name":"myfs-b","rank":0,"state":"standby"}]}`
+	require.Nil(t, json.Unmarshal([]byte(output), &status))
+	err := status.verifyStandbyReplay(1)
+	require.NotNil(t, err)
+	assert.Contains(t, err.Error(), "found 1 active and 0 standby-replay")
+}
+
+func TestConfigDump(t *testing.T) {
+	output := `[{"section":"global","mask":"","name":"mon_allow_pool_delete","value":"true","level":"advanced"},
+		{"section":"osd","mask":"host:node1","name":"osd_max_backfills","value":"2","level":"advanced"}]`
+	before, err := parseConfigDump([]byte(output))
+	require.Nil(t, err)
+	assert.Equal(t, map[string]string{"global/mon_allow_pool_delete": "true", "osd/host:node1/osd_max_backfills": "2"}, before)
+
+	_, err = parseConfigDump([]byte("not json"))
+	assert.NotNil(t, err)
+
+	assert.Equal(t, 0, len(DiffConfigDump(before, before)))
+
+	after := map[string]string{"global/mon_allow_pool_delete": "false", "mgr/mgr/balancer/active": "1"}
+	assert.Equal(t, []ConfigChange{
+		{Key: "global/mon_allow_pool_delete", Before: "true", After: "false"},
+		{Key: "mgr/mgr/balancer/active", After: "1"},
+		{Key: "osd/host:node1/osd_max_backfills", Before: "2"},
+	}, DiffConfigDump(before, after))
+}
+
+func TestParsePrometheusMetrics(t *testing.T) {
+	metrics, err := parsePrometheusMetrics(`# HELP ceph_osd_op_r Client read operations
+# TYPE ceph_osd_op_r counter
+ceph_osd_op_r{ceph_daemon="osd.0"} 42
+ceph_osd_op_r{ceph_daemon="osd.1",hostname="node 1"} 7 1560000000000
+
+ceph_rbd_mirror_snapshots 1.5e+02
+`)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]float64{
+		`ceph_osd_op_r{ceph_daemon="osd.0"}`:                   42,
+		`ceph_osd_op_r{ceph_daemon="osd.1",hostname="node 1"}`: 7,
+		"ceph_rbd_mirror_snapshots":                            150,
+	}, metrics)
+
+	_, err = parsePrometheusMetrics("ceph_osd_op_r")
+	assert.NotNil(t, err)
+	_, err = parsePrometheusMetrics("ceph_osd_op_r abc")
+	assert.NotNil(t, err)
+}
+
+func TestParseDiscoveredDevices(t *testing.T) {
+	devices, err := parseDiscoveredDevices(`[{"name":"sda","parent":"","hasChildren":true,"size":10737418240,"type":"disk",
+		"rotational":true,"filesystem":"","empty":false},{"name":"sdb","size":21474836480,"type":"disk","empty":true}]`)
+	assert.Nil(t, err)
+	assert.Equal(t, []Device{
+		{Name: "sda", Size: 10737418240, Type: "disk", Rotational: true, HasChildren: true},
+		{Name: "sdb", Size: 21474836480, Type: "disk", Empty: true},
+	}, devices)
+
+	devices, err = parseDiscoveredDevices("[]")
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(devices))
+
+	_, err = parseDiscoveredDevices("")
+	assert.NotNil(t, err)
+	_, err = parseDiscoveredDevices("invalid")
+	assert.NotNil(t, err)
+}
+
+func TestDataDirHostPathPerCluster(t *testing.T) {
+	h := &CephInstaller{}
+	dir1, err := h.initTestDir("cluster1")
+	require.Nil(t, err)
+	dir2, err := h.initTestDir("cluster2")
+	require.Nil(t, err)
+	if createBaseTestDir {
+		defer os.RemoveAll(h.hostPathToDelete)
+	}
+
+	assert.NotEqual(t, dir1, dir2)
+	assert.Equal(t, "cluster1", path.Base(path.Dir(dir1)))
+	assert.Equal(t, "cluster2", path.Base(path.Dir(dir2)))
+	assert.Equal(t, dir1, h.DataDirHostPath("cluster1"))
+	assert.Equal(t, dir2, h.DataDirHostPath("cluster2"))
+	assert.Equal(t, "", h.DataDirHostPath("other"))
+
+	// the base dir is only removed with the last cluster
+	assert.Equal(t, []string{dir1}, h.hostPathsToClean([]string{"cluster1"}))
+	assert.Equal(t, "", h.DataDirHostPath("cluster1"))
+	assert.Equal(t, []string{dir2, h.hostPathToDelete}, h.hostPathsToClean([]string{"cluster1", "cluster2"}))
+}
+
+func TestDataDirOnTmpfs(t *testing.T) {
+	h := &CephInstaller{DataDirOnTmpfs: true}
+	dir, err := h.initTestDir("cluster1")
+	require.Nil(t, err)
+
+	assert.True(t, strings.HasPrefix(dir, "/dev/shm/rook-test/cluster1/test-"))
+	assert.Equal(t, "/dev/shm/rook-test", h.hostPathToDelete)
+	assert.Equal(t, dir, h.DataDirHostPath("cluster1"))
+
+	// the dir is not created on the local host
+	_, err = os.Stat(dir)
+	assert.True(t, os.IsNotExist(err))
+
+	// the rendered cluster points at the tmpfs dir
+	settings := testClusterSettings()
+	settings.DataDirHostPath = dir
+	spec := getSpec(t, (&CephManifestsMaster{imageTag: VersionMaster}).GetRookCluster(settings))
+	assert.Equal(t, dir, spec["dataDirHostPath"])
+
+	assert.True(t, isTmpfs("tmpfs\n"))
+	assert.False(t, isTmpfs("ext2/ext3\n"))
+	assert.False(t, isTmpfs(""))
+}
+
+func TestCrushRuleDeviceClasses(t *testing.T) {
+	rule := `{"rule_id":1,"rule_name":"store.rgw.buckets.index","steps":[
+		{"op":"take","item":-2,"item_name":"default~nvme"},
+		{"op":"chooseleaf_firstn","num":0,"type":"host"},
+		{"op":"emit"}]}`
+	classes, err := crushRuleDeviceClasses([]byte(rule))
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"nvme"}, classes)
+
+	rule = `{"rule_id":0,"rule_name":"replicated_rule","steps":[{"op":"take","item":-1,"item_name":"default"},{"op":"emit"}]}`
+	classes, err = crushRuleDeviceClasses([]byte(rule))
+	assert.Nil(t, err)
+	assert.Equal(t, []string{""}, classes)
+
+	_, err = crushRuleDeviceClasses([]byte("invalid"))
+	assert.NotNil(t, err)
+}
+
+func TestParseOrchestratorStatus(t *testing.T) {
+	status := parseOrchestratorStatus("Backend: rook\nAvailable: True\n")
+	assert.Equal(t, "rook", status.Backend)
+	assert.True(t, status.active())
+
+	status = parseOrchestratorStatus("Backend: rook\nAvailable: False (Unable to reach the k8s api: timeout)")
+	assert.False(t, status.active())
+	assert.Equal(t, "Unable to reach the k8s api: timeout", status.Message)
+
+	// the backend was not set or does not report its availability
+	assert.False(t, parseOrchestratorStatus("No orchestrator configured (try `ceph orchestrator set backend`)").active())
+	assert.False(t, parseOrchestratorStatus("Backend: rook").active())
+}
+
+func TestInterfaceWithIP(t *testing.T) {
+	addrs := `1: lo    inet 127.0.0.1/8 scope host lo\       valid_lft forever preferred_lft forever
+2: eth0    inet 10.0.0.5/24 brd 10.0.0.255 scope global eth0\       valid_lft forever preferred_lft forever
+3: eth1    inet 192.168.10.5/24 brd 192.168.10.255 scope global eth1\       valid_lft forever preferred_lft forever`
+	assert.Equal(t, "eth1", interfaceWithIP(addrs, "192.168.10.5"))
+	assert.Equal(t, "eth0", interfaceWithIP(addrs, "10.0.0.5"))
+	assert.Equal(t, "", interfaceWithIP(addrs, "10.0.0.50"))
+
+	assert.Equal(t, "192.168.10.5", osdAddrIPRegex.FindStringSubmatch("192.168.10.5:6801/1234")[1])
+	assert.Equal(t, "192.168.10.5", osdAddrIPRegex.FindStringSubmatch("[v2:192.168.10.5:6802/1234,v1:192.168.10.5:6803/1234]")[1])
+}
+
+func TestWriteTarGz(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bundle")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	require.Nil(t, writeSupportFile(path.Join(dir, "ceph", "status.json"), []byte(`{"health":"HEALTH_OK"}`)))
+	require.Nil(t, writeSupportFile(path.Join(dir, "errors.txt"), []byte("failed\n")))
+
+	out := path.Join(dir, "..", path.Base(dir)+".tar.gz")
+	require.Nil(t, writeTarGz(dir, out))
+	defer os.Remove(out)
+
+	f, err := os.Open(out)
+	require.Nil(t, err)
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	require.Nil(t, err)
+	tr := tar.NewReader(gz)
+	files := map[string]string{}
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		require.Nil(t, err)
+		data, err := ioutil.ReadAll(tr)
+		require.Nil(t, err)
+		files[header.Name] = string(data)
+	}
+	assert.Equal(t, map[string]string{"ceph/status.json": `{"health":"HEALTH_OK"}`, "errors.txt": "failed\n"}, files)
+}
+
+func TestLoadBalancerAddress(t *testing.T) {
+	svc := &v1.Service{}
+	assert.Equal(t, "", loadBalancerAddress(svc))
+
+	svc.Status.LoadBalancer.Ingress = []v1.LoadBalancerIngress{{Hostname: "dashboard.example.com"}}
+	assert.Equal(t, "dashboard.example.com", loadBalancerAddress(svc))
+
+	svc.Status.LoadBalancer.Ingress = []v1.LoadBalancerIngress{{IP: "203.0.113.10"}}
+	assert.Equal(t, "203.0.113.10", loadBalancerAddress(svc))
+}
+
+func TestCheckHTTPOK(t *testing.T) {
+	status := http.StatusServiceUnavailable
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	assert.NotNil(t, checkHTTPOK(server.URL))
+	status = http.StatusOK
+	assert.Nil(t, checkHTTPOK(server.URL))
+}
+
+func TestMetadataDeviceMismatches(t *testing.T) {
+	metadata := map[string]interface{}{
+		"bluefs_dedicated_db":     "1",
+		"bluefs_db_dev_node":      "nvme0n1",
+		"bluefs_dedicated_wal":    "1",
+		"bluefs_wal_dev_node":     "nvme0n1",
+		"bluestore_bdev_dev_node": "sdb",
+	}
+	assert.Equal(t, 0, len(metadataDeviceMismatches(metadata, "/dev/nvme0n1")))
+
+	// the wal may be kept with the db
+	metadata["bluefs_dedicated_wal"] = "0"
+	assert.Equal(t, 0, len(metadataDeviceMismatches(metadata, "nvme0n1")))
+
+	metadata["bluefs_dedicated_wal"] = "1"
+	metadata["bluefs_wal_dev_node"] = "sdc"
+	assert.Equal(t, []string{"the wal is on sdc"}, metadataDeviceMismatches(metadata, "nvme0n1"))
+	assert.Equal(t, []string{"the db is on nvme0n1", "the wal is on sdc"}, metadataDeviceMismatches(metadata, "nvme1n1"))
+
+	metadata = map[string]interface{}{"bluefs_dedicated_db": "0", "bluefs_dedicated_wal": "0"}
+	assert.Equal(t, []string{"the db is not on a dedicated device"}, metadataDeviceMismatches(metadata, "nvme0n1"))
+}
+
+func TestEntriesBehindMaster(t *testing.T) {
+	status := `{"name":"image1","global_id":"123","state":"up+replaying","description":"replaying, master_position=[object_number=3, tag_tid=1, entry_tid=3000], mirror_position=[object_number=2, tag_tid=1, entry_tid=2000], entries_behind_master=1000","last_update":"2019-06-01 10:00:00"}`
+	behind, err := entriesBehindMaster([]byte(status))
+	assert.Nil(t, err)
+	assert.Equal(t, 1000, behind)
+
+	status = `{"name":"image1","state":"up+replaying","description":"replaying, master_position=[], mirror_position=[], entries_behind_master=0"}`
+	behind, err = entriesBehindMaster([]byte(status))
+	assert.Nil(t, err)
+	assert.Equal(t, 0, behind)
+
+	_, err = entriesBehindMaster([]byte(`{"name":"image1","state":"up+syncing","description":"bootstrapping, IMAGE_COPY/COPY_OBJECT 50%"}`))
+	assert.NotNil(t, err)
+	_, err = entriesBehindMaster([]byte("invalid"))
+	assert.NotNil(t, err)
+}
+
+func TestMissingOwnerReferences(t *testing.T) {
+	owned := metav1.ObjectMeta{Name: "rook-ceph-mon-a", OwnerReferences: []metav1.OwnerReference{{Kind: "CephCluster", UID: "uid"}}}
+	otherCluster := metav1.ObjectMeta{Name: "rook-ceph-mgr-a", OwnerReferences: []metav1.OwnerReference{{Kind: "CephCluster", UID: "other"}}}
+	resources := map[string][]metav1.ObjectMeta{
+		"Deployment": {owned, otherCluster, {Name: "rook-ceph-tools"}},
+		"Secret":     {{Name: "default-token-abcde"}, {Name: "rook-ceph-mon"}},
+		"ConfigMap":  {{Name: "rook-config-override"}},
+	}
+	skip := map[string]bool{"Deployment/rook-ceph-tools": true, "ConfigMap/rook-config-override": true}
+	assert.Equal(t, []string{"Deployment/rook-ceph-mgr-a", "Secret/rook-ceph-mon"}, missingOwnerReferences(resources, "uid", skip))
+	assert.Nil(t, missingOwnerReferences(map[string][]metav1.ObjectMeta{"Deployment": {owned}}, "uid", skip))
+}
+
+func TestQuotaWrites(t *testing.T) {
+	assert.Equal(t, 11, quotaWrites(PoolSpec{MaxBytes: 10 * quotaTestObjectSize}))
+	assert.Equal(t, 6, quotaWrites(PoolSpec{MaxObjects: 5}))
+	assert.Equal(t, 3, quotaWrites(PoolSpec{MaxBytes: 10 * quotaTestObjectSize, MaxObjects: 2}))
+	assert.Equal(t, 1, quotaWrites(PoolSpec{MaxBytes: quotaTestObjectSize / 2, MaxObjects: 5}))
+}
+
+func TestOSDsBelowPGShare(t *testing.T) {
+	distribution := map[int]int{0: 40, 1: 40, 2: 30, 3: 10}
+	assert.Nil(t, osdsBelowPGShare(distribution, []int{2}))
+	assert.Equal(t, []string{"osd.3 has 10 pgs of 30.0 on average", "osd.4 is not in the distribution"}, osdsBelowPGShare(distribution, []int{2, 3, 4}))
+	assert.Nil(t, osdsBelowPGShare(map[int]int{}, []int{0}))
+}
+
+func TestFormatPGStates(t *testing.T) {
+	states := []client.PgStateEntry{{StateName: "active+undersized+degraded", Count: 4}, {StateName: "active+clean", Count: 28}}
+	assert.Equal(t, "active+clean=28, active+undersized+degraded=4", formatPGStates(states))
+	assert.Equal(t, "", formatPGStates(nil))
+}
+
+func TestTopologyLocations(t *testing.T) {
+	nodes := []v1.Node{
+		{ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"kubernetes.io/hostname": "node1", "topology.rook.io/rack": "rack1"}}},
+		{ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"kubernetes.io/hostname": "node2"}}},
+	}
+	base := map[string]map[string]string{"node2": {"zone": "a"}}
+	locations, err := topologyLocations(nodes, "topology.rook.io/rack", base)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]map[string]string{"node1": {"rack": "rack1"}, "node2": {"zone": "a"}}, locations)
+	// the locations of the installer are not modified
+	assert.Equal(t, map[string]map[string]string{"node2": {"zone": "a"}}, base)
+
+	_, err = topologyLocations(nodes, "example.com/zone", nil)
+	assert.NotNil(t, err)
+}
+
+func TestParseClusterCapacity(t *testing.T) {
+	total, used, available, err := parseClusterCapacity([]byte(`{"stats": {"total_bytes": 32212254720, "total_used_bytes": 3221225472, "total_avail_bytes": 28991029248}, "pools": []}`))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(32212254720), total)
+	assert.Equal(t, int64(3221225472), used)
+	assert.Equal(t, int64(28991029248), available)
+
+	_, _, _, err = parseClusterCapacity([]byte(`{"stats": {"total_bytes": 1}}`))
+	assert.EqualError(t, err, `ceph df does not report the capacity of the cluster: {"stats": {"total_bytes": 1}}`)
+
+	_, _, _, err = parseClusterCapacity([]byte(`GLOBAL: SIZE AVAIL RAW USED`))
+	assert.NotNil(t, err)
+}
+
+func TestUnregisteredNodes(t *testing.T) {
+	output := []byte(`{"items": [
+		{"metadata": {"name": "node1"}, "spec": {"drivers": [{"name": "csi-rbdplugin"}, {"name": "csi-cephfsplugin"}]}},
+		{"metadata": {"name": "node2"}, "spec": {"drivers": [{"name": "csi-cephfsplugin"}]}}]}`)
+	missing, err := unregisteredNodes(output, rbdDriverName, []string{"node1", "node2", "node3"})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"node2", "node3"}, missing)
+
+	missing, err = unregisteredNodes(output, cephfsDriverName, []string{"node1", "node2"})
+	assert.Nil(t, err)
+	assert.Empty(t, missing)
+
+	_, err = unregisteredNodes([]byte("not json"), rbdDriverName, nil)
+	assert.NotNil(t, err)
+}
+
+func TestClusterUpdateDone(t *testing.T) {
+	done, err := clusterUpdateDone(false, cephv1.ClusterStatus{State: cephv1.ClusterStateCreated})
+	assert.False(t, done)
+	assert.Nil(t, err)
+
+	done, err = clusterUpdateDone(true, cephv1.ClusterStatus{State: cephv1.ClusterStateUpdating})
+	assert.False(t, done)
+	assert.Nil(t, err)
+
+	done, err = clusterUpdateDone(true, cephv1.ClusterStatus{State: cephv1.ClusterStateCreated})
+	assert.True(t, done)
+	assert.Nil(t, err)
+
+	done, err = clusterUpdateDone(false, cephv1.ClusterStatus{State: cephv1.ClusterStateError, Message: "failed"})
+	assert.True(t, done)
+	assert.NotNil(t, err)
+}
+
+func TestNewCephClusterStatus(t *testing.T) {
+	var ceph client.CephStatus
+	require.Nil(t, json.Unmarshal([]byte(`{"health": {"status": "HEALTH_WARN"},
+		"monmap": {"mons": [{"name": "a"}, {"name": "b"}, {"name": "c"}]},
+		"osdmap": {"osdmap": {"num_osds": 3, "num_up_osds": 2}}}`), &ceph))
+	status := newCephClusterStatus(cephv1.ClusterStatus{State: cephv1.ClusterStateCreated, Message: "Cluster created successfully"}, ceph)
+	assert.Equal(t, CephClusterStatus{State: cephv1.ClusterStateCreated, Message: "Cluster created successfully", Health: "HEALTH_WARN", MonCount: 3, OSDCount: 3}, status)
+}
+
+func TestVerifyUserQuota(t *testing.T) {
+	assert.Nil(t, verifyUserQuota(`{"user_id": "tenant", "user_quota": {"enabled": true, "max_size": 1048576, "max_objects": -1}}`, 1048576))
+	assert.NotNil(t, verifyUserQuota(`{"user_id": "tenant", "user_quota": {"enabled": false, "max_size": 1048576}}`, 1048576))
+	assert.NotNil(t, verifyUserQuota(`{"user_id": "tenant", "user_quota": {"enabled": true, "max_size": -1}}`, 1048576))
+	assert.NotNil(t, verifyUserQuota("not json", 1048576))
+}
+
+func TestReconcileStats(t *testing.T) {
+	before, err := parsePrometheusMetrics(`
+controller_runtime_reconcile_time_seconds_bucket{controller="ceph-block-pool-controller",le="0.1"} 5
+controller_runtime_reconcile_time_seconds_bucket{controller="ceph-block-pool-controller",le="1"} 5
+controller_runtime_reconcile_time_seconds_bucket{controller="ceph-block-pool-controller",le="10"} 5
+controller_runtime_reconcile_time_seconds_bucket{controller="ceph-block-pool-controller",le="+Inf"} 5
+controller_runtime_reconcile_errors_total{controller="ceph-block-pool-controller"} 1
+`)
+	require.Nil(t, err)
+	after, err := parsePrometheusMetrics(`
+controller_runtime_reconcile_time_seconds_bucket{controller="ceph-block-pool-controller",le="0.1"} 55
+controller_runtime_reconcile_time_seconds_bucket{controller="ceph-block-pool-controller",le="1"} 95
+controller_runtime_reconcile_time_seconds_bucket{controller="ceph-block-pool-controller",le="10"} 104
+controller_runtime_reconcile_time_seconds_bucket{controller="ceph-block-pool-controller",le="+Inf"} 105
+controller_runtime_reconcile_time_seconds_bucket{controller="ceph-cluster-controller",le="+Inf"} 300
+controller_runtime_reconcile_errors_total{controller="ceph-block-pool-controller"} 3
+`)
+	require.Nil(t, err)
+
+	stats := reconcileStats(before, after, poolControllerName)
+	assert.Equal(t, ReconcileStats{Reconciles: 100, Errors: 2, P50: 100 * time.Millisecond, P90: time.Second, P99: 10 * time.Second}, stats)
+	assert.Equal(t, ReconcileStats{}, reconcileStats(after, after, poolControllerName))
+}
+
+func TestWithoutNode(t *testing.T) {
+	nodes := []rookalpha.Node{{Name: "node1"}, {Name: "node2"}, {Name: "node3"}}
+	result, found := withoutNode(nodes, "node2")
+	assert.True(t, found)
+	assert.Equal(t, []rookalpha.Node{{Name: "node1"}, {Name: "node3"}}, result)
+
+	result, found = withoutNode(nodes, "other")
+	assert.False(t, found)
+	assert.Equal(t, nodes, result)
+
+	result, found = withoutNode([]rookalpha.Node{{Name: "node1"}}, "node1")
+	assert.True(t, found)
+	assert.Empty(t, result)
+}
+
+func TestParseCephConfig(t *testing.T) {
+	config := `# rook overrides
+[global]
+osd_pool_default_size = 1
+[osd.0]
+debug_osd = 20/20
+mon_host=a
+`
+	expected := map[string]map[string]string{
+		"global": {"osd_pool_default_size": "1"},
+		"osd.0":  {"debug_osd": "20/20", "mon_host": "a"},
+	}
+	assert.Equal(t, expected, parseCephConfig(config))
+	assert.Equal(t, expected, parseCephConfig(renderCephConfig(expected, 0)))
+	assert.Empty(t, parseCephConfig(""))
+	assert.Empty(t, parseCephConfig("key = outside of a section"))
+}
+
+func TestConfigDaemonSelectors(t *testing.T) {
+	selectors, err := configDaemonSelectors("osd.1")
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{"osd": "app=rook-ceph-osd,ceph-osd-id=1"}, selectors)
+
+	selectors, err = configDaemonSelectors("mon")
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{"mon": "app=rook-ceph-mon"}, selectors)
+
+	selectors, err = configDaemonSelectors("global")
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{"mon": "app=rook-ceph-mon", "mgr": "app=rook-ceph-mgr", "osd": "app=rook-ceph-osd"}, selectors)
+
+	_, err = configDaemonSelectors("client.rgw")
+	assert.NotNil(t, err)
+}
+
+func TestPodsReplaced(t *testing.T) {
+	pod := func(name string, phase v1.PodPhase) v1.Pod {
+		return v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: name}, Status: v1.PodStatus{Phase: phase}}
+	}
+	assert.True(t, podsReplaced([]v1.Pod{pod("mon-a-2", v1.PodRunning), pod("mon-b-1", v1.PodRunning)}, "mon-a-1", 2))
+	assert.False(t, podsReplaced([]v1.Pod{pod("mon-a-1", v1.PodRunning), pod("mon-b-1", v1.PodRunning)}, "mon-a-1", 2))
+	assert.False(t, podsReplaced([]v1.Pod{pod("mon-a-2", v1.PodPending), pod("mon-b-1", v1.PodRunning)}, "mon-a-1", 2))
+	assert.False(t, podsReplaced([]v1.Pod{pod("mon-b-1", v1.PodRunning)}, "mon-a-1", 2))
+}
+
+func TestRotateOSDEncryptionKeysRequiresEncryption(t *testing.T) {
+	h := &CephInstaller{}
+	assert.NotNil(t, h.RotateOSDEncryptionKeys("rook-ceph"))
+}
+
+func TestOSDUUIDs(t *testing.T) {
+	uuids, err := osdUUIDs([]byte(`{"osds":[{"osd":0,"uuid":"a1b2","up":1,"in":1},{"osd":2,"uuid":"c3d4","up":0,"in":1}]}`))
+	assert.Nil(t, err)
+	assert.Equal(t, map[int]string{0: "a1b2", 2: "c3d4"}, uuids)
+
+	_, err = osdUUIDs([]byte("not json"))
+	assert.NotNil(t, err)
+}
+
+func TestDMCryptDevicePath(t *testing.T) {
+	assert.Equal(t, "/dev/mapper/a1b2", dmcryptDevicePath(map[string]interface{}{"bluestore_bdev_partition_path": "/dev/mapper/a1b2"}))
+	assert.Equal(t, "/dev/dm-3", dmcryptDevicePath(map[string]interface{}{"backend_filestore_partition_path": "/dev/dm-3"}))
+	assert.Equal(t, "", dmcryptDevicePath(map[string]interface{}{"bluestore_bdev_partition_path": "/dev/sdb2"}))
+	assert.Equal(t, "", dmcryptDevicePath(map[string]interface{}{"osd_objectstore": "filestore"}))
+}
+
+func TestLUKSKeys(t *testing.T) {
+	assert.Equal(t, "dm-crypt/osd/a1b2/luks", luksKeyName("a1b2"))
+	assert.Equal(t, "c2VjcmV0", configKeyValue([]byte(`"c2VjcmV0"`)))
+	assert.Equal(t, "c2VjcmV0", configKeyValue([]byte("c2VjcmV0\n")))
+
+	key, err := newLUKSKey()
+	require.Nil(t, err)
+	decoded, err := base64.StdEncoding.DecodeString(key)
+	assert.Nil(t, err)
+	assert.Equal(t, luksKeySize, len(decoded))
+	other, err := newLUKSKey()
+	require.Nil(t, err)
+	assert.NotEqual(t, key, other)
+
+	script := renderKeyRotationScript("/dev/mapper/a1b2", "b2xk", "bmV3")
+	assert.Contains(t, script, "printf '%s' 'b2xk' > /tmp/old-key")
+	assert.Contains(t, script, "printf '%s' 'bmV3' > /tmp/new-key")
+	assert.Contains(t, script, "mapped=$(readlink -f /dev/mapper/a1b2)")
+	assert.Contains(t, script, "cryptsetup -q luksAddKey --key-file /tmp/old-key $luks /tmp/new-key")
+	assert.Contains(t, script, "cryptsetup -q luksRemoveKey $luks /tmp/old-key")
+}
+
+func TestMeasureProvisioningLatencyCount(t *testing.T) {
+	h := &CephInstaller{}
+	for _, count := range []int{0, -1} {
+		latencies, err := h.MeasureProvisioningLatency("rook-ceph", "rook-ceph-block", count)
+		assert.NotNil(t, err)
+		assert.Nil(t, latencies)
+	}
+}
+
+func TestStressObjectStoreCounts(t *testing.T) {
+	h := &CephInstaller{}
+	assert.NotNil(t, h.StressObjectStore("rook-ceph", "store", -1, 1))
+	assert.NotNil(t, h.StressObjectStore("rook-ceph", "store", 0, 1))
+	assert.NotNil(t, h.StressObjectStore("rook-ceph", "store", 1, -1))
+	assert.NotNil(t, h.StressObjectStore("rook-ceph", "store", 1, 0))
+}
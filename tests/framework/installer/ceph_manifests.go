@@ -18,30 +18,64 @@ package installer
 
 import (
 	"fmt"
+	"sort"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/google/uuid"
 	cephv1 "github.com/rook/rook/pkg/apis/ceph.rook.io/v1"
+	"github.com/rook/rook/pkg/operator/k8sutil"
 )
 
 type CephManifests interface {
 	GetRookCRDs() string
-	GetRookOperator(namespace string) string
+	GetRookOperator(settings *OperatorSettings) string
 	GetClusterRoles(namespace, systemNamespace string) string
 	GetRookCluster(settings *ClusterSettings) string
-	GetRookToolBox(namespace string) string
+	GetRookToolBox(settings *ToolboxSettings) string
 	GetCleanupPod(node, removalDir string) string
 	GetBlockPoolDef(poolName string, namespace string, replicaSize string) string
+	GetBlockPools(namespace string, pools []PoolSpec) string
 	GetBlockStorageClassDef(poolName string, storageClassName string, reclaimPolicy string, namespace string, varClusterName bool) string
+	GetBlockStorageClassWithMetadata(poolName, storageClassName, reclaimPolicy, namespace string, varClusterName bool, labels, annotations map[string]string) string
 	GetBlockPvcDef(claimName string, storageClassName string, accessModes string) string
 	GetBlockPoolStorageClassAndPvcDef(namespace string, poolName string, storageClassName string, reclaimPolicy string, blockName string, accessMode string) string
 	GetBlockPoolStorageClass(namespace string, poolName string, storageClassName string, reclaimPolicy string) string
 	GetFilesystem(namepace, name string, activeCount int) string
+	GetFilesystemWithStandby(namespace, name string, activeCount int, activeStandby bool) string
 	GetObjectStore(namespace, name string, replicaCount, port int) string
+	GetObjectStoreWithPools(namespace, name string, replicaCount, port int, metadataPool, dataPool ObjectPoolSpec) string
 	GetObjectStoreUser(namespace, name string, displayName string, store string) string
+	GetObjectStoreWithDNSNames(namespace, name string, replicaCount, port int, dnsNames []string) string
+}
+
+// OperatorSettings are the options to render the operator manifest
+type OperatorSettings struct {
+	Namespace          string
+	EnableRBDDriver    bool
+	EnableCephFSDriver bool
+	// DiscoverDevicesInterval is how often the discover daemons look for new devices. The operator default is used if
+	// not set.
+	DiscoverDevicesInterval time.Duration
+	// ImagePullSecrets are the secrets to pull the operator image from a private registry
+	ImagePullSecrets []string
+}
+
+// renderDiscoverDevicesInterval returns the env var of the operator with the discover interval, or an empty string if
+// the interval is not set
+func renderDiscoverDevicesInterval(interval time.Duration) string {
+	if interval <= 0 {
+		return ""
+	}
+	return `
+        - name: ROOK_DISCOVER_DEVICES_INTERVAL
+          value: ` + strconv.Quote(interval.String())
 }
 
 type ClusterSettings struct {
+	// APIVersion of the CephCluster CR. Defaults to ceph.rook.io/v1 if not set.
+	APIVersion       string
 	Namespace        string
 	StoreType        string
 	DataDirHostPath  string
@@ -49,6 +83,171 @@ type ClusterSettings struct {
 	Mons             int
 	RBDMirrorWorkers int
 	CephVersion      cephv1.CephVersionSpec
+	// Nodes are the hostnames of the nodes to run osds on. All nodes are used if empty.
+	Nodes []string
+	// NodeLocations are the crush locations of the osds of the nodes, keyed by hostname and then by the crush bucket
+	// type, such as {"node1": {"rack": "rack1"}}. Only the locations of the listed nodes are rendered.
+	NodeLocations map[string]map[string]string
+	// NodeMetadataDevices are the devices for the bluestore db and wal of the osds of the nodes, keyed by hostname.
+	// Only the devices of the listed nodes are rendered.
+	NodeMetadataDevices map[string]string
+	// ConfigOverrides are merged into the ceph.conf of the daemons, keyed by section (global, osd, mon.a, ...) and
+	// then by setting name
+	ConfigOverrides map[string]map[string]string
+	// RecoveryThrottle is added to the osd section of the config overrides if set
+	RecoveryThrottle *RecoveryThrottle
+	// EncryptedDevices creates the osds on devices with dm-crypt
+	EncryptedDevices bool
+	// DashboardServiceType exposes the dashboard outside of the cluster with a service of the type (NodePort or
+	// LoadBalancer) if set
+	DashboardServiceType string
+	// SkipOSDCreation renders the storage without any node, so the operator only starts the mons and the mgr and the
+	// osds can be added by the test afterwards, see AddDeviceAndMeasure. The install confirms no osd is created.
+	SkipOSDCreation bool
+}
+
+// RecoveryThrottle limits the concurrent backfills and recovery operations of each osd. Zero values keep the ceph
+// defaults.
+type RecoveryThrottle struct {
+	MaxBackfills      int
+	RecoveryMaxActive int
+}
+
+// settings returns the ceph settings of the throttle that are not zero
+func (r *RecoveryThrottle) settings() map[string]string {
+	settings := map[string]string{}
+	if r.MaxBackfills > 0 {
+		settings["osd_max_backfills"] = strconv.Itoa(r.MaxBackfills)
+	}
+	if r.RecoveryMaxActive > 0 {
+		settings["osd_recovery_max_active"] = strconv.Itoa(r.RecoveryMaxActive)
+	}
+	return settings
+}
+
+// ToolboxSettings are the options of the toolbox pod
+type ToolboxSettings struct {
+	Namespace   string
+	Labels      map[string]string
+	Annotations map[string]string
+	// ServiceAccount runs the toolbox pod with the account instead of the default service account of the namespace
+	ServiceAccount string
+}
+
+// PoolSpec is the configuration of a CephBlockPool to render
+type PoolSpec struct {
+	Name     string
+	Replicas int
+	// FailureDomain of the pool (osd or host). The operator default is used if empty.
+	FailureDomain string
+	// CompressionMode is the bluestore inline compression mode (none, passive, aggressive or force). Compression is
+	// left at the ceph default if empty.
+	CompressionMode string
+	// CompressionAlgorithm is the bluestore compression algorithm (snappy, zlib, zstd or lz4)
+	CompressionAlgorithm string
+	// MirroringMode enables rbd mirroring on the pool in the given mode (image or pool). Mirroring is disabled if
+	// empty.
+	MirroringMode string
+	// MaxBytes and MaxObjects are the quotas of the pool. Zero values leave the pool without a quota.
+	MaxBytes   uint64
+	MaxObjects uint64
+}
+
+// ObjectPoolSpec is the configuration of the metadata or data pools of an object store. The pool is erasure coded if
+// DataChunks is set, otherwise it is replicated.
+type ObjectPoolSpec struct {
+	Replicas     int
+	DataChunks   int
+	CodingChunks int
+	// FailureDomain of the pool (osd or host). The operator default is used if empty.
+	FailureDomain string
+	// DeviceClass restricts the pool to the osds of the crush device class (e.g. nvme or ssd). All the devices are
+	// used if empty.
+	DeviceClass string
+}
+
+// the object store pools if no other pool config is requested
+var defaultObjectPool = ObjectPoolSpec{Replicas: 1}
+
+func (p *ObjectPoolSpec) erasureCoded() bool {
+	return p.DataChunks > 0
+}
+
+// MDSPodCount returns the number of mds pods the operator starts for the filesystem with the active count: one for each
+// active rank and one standby for each active. The count is the same whether or not the standbys are standby-replay.
+func MDSPodCount(activeCount int) int {
+	return activeCount * 2
+}
+
+// renderObjectPool renders the pool section of an object store spec
+func renderObjectPool(section string, pool ObjectPoolSpec) string {
+	manifest := `
+  ` + section + `:`
+	if pool.FailureDomain != "" {
+		manifest += `
+    failureDomain: ` + pool.FailureDomain
+	}
+	if pool.DeviceClass != "" {
+		manifest += `
+    deviceClass: ` + pool.DeviceClass
+	}
+	if pool.erasureCoded() {
+		return manifest + `
+    erasureCoded:
+      dataChunks: ` + strconv.Itoa(pool.DataChunks) + `
+      codingChunks: ` + strconv.Itoa(pool.CodingChunks)
+	}
+	return manifest + `
+    replicated:
+      size: ` + strconv.Itoa(pool.Replicas)
+}
+
+// renderObjectStoreHosting renders the dns names of the rgw, so buckets are also addressed as <bucket>.<dns name>
+// with virtual-hosted-style requests. The rgw sets the names as the rgw dns name.
+func renderObjectStoreHosting(dnsNames []string) string {
+	if len(dnsNames) == 0 {
+		return ""
+	}
+	hosting := `
+  hosting:
+    dnsNames:`
+	for _, name := range dnsNames {
+		hosting += `
+    - ` + name
+	}
+	return hosting + `
+`
+}
+
+func (p *PoolSpec) hasQuota() bool {
+	return p.MaxBytes > 0 || p.MaxObjects > 0
+}
+
+// compressionParameters returns the ceph pool properties for the compression settings of the pool
+func (p *PoolSpec) compressionParameters() map[string]string {
+	parameters := map[string]string{}
+	if p.CompressionMode != "" {
+		parameters["compression_mode"] = p.CompressionMode
+	}
+	if p.CompressionAlgorithm != "" {
+		parameters["compression_algorithm"] = p.CompressionAlgorithm
+	}
+	return parameters
+}
+
+const (
+	cephClusterCRDName = "cephclusters.ceph.rook.io"
+	// the api version of the cluster CR if no other version is requested
+	defaultClusterAPIVersion = "ceph.rook.io/v1"
+	// DefaultStorageClassAnnotation marks a storage class as the default of the cluster when set to "true"
+	DefaultStorageClassAnnotation = "storageclass.kubernetes.io/is-default-class"
+)
+
+func (s *ClusterSettings) clusterAPIVersion() string {
+	if s.APIVersion == "" {
+		return defaultClusterAPIVersion
+	}
+	return s.APIVersion
 }
 
 // CephManifestsMaster wraps rook yaml definitions
@@ -226,7 +425,8 @@ spec:
 }
 
 // GetRookOperator returns rook Operator manifest
-func (m *CephManifestsMaster) GetRookOperator(namespace string) string {
+func (m *CephManifestsMaster) GetRookOperator(settings *OperatorSettings) string {
+	namespace := settings.Namespace
 	return `kind: Namespace
 apiVersion: v1
 metadata:
@@ -717,7 +917,7 @@ spec:
       labels:
         app: rook-ceph-operator
     spec:
-      serviceAccountName: rook-ceph-system
+      serviceAccountName: rook-ceph-system` + renderImagePullSecrets(settings.ImagePullSecrets, 6) + `
       containers:
       - name: rook-ceph-operator
         image: rook/ceph:` + m.imageTag + `
@@ -728,7 +928,7 @@ spec:
         - name: ROOK_MON_HEALTHCHECK_INTERVAL
           value: "10s"
         - name: ROOK_MON_OUT_TIMEOUT
-          value: "15s"
+          value: "15s"` + renderDiscoverDevicesInterval(settings.DiscoverDevicesInterval) + `
         - name: NODE_NAME
           valueFrom:
             fieldRef:
@@ -743,11 +943,11 @@ spec:
               fieldPath: metadata.namespace
         # CSI enablement
         - name: ROOK_CSI_ENABLE_CEPHFS
-          value: "true"
+          value: "` + strconv.FormatBool(settings.EnableCephFSDriver) + `"
         - name: ROOK_CSI_CEPHFS_IMAGE
           value: "quay.io/cephcsi/cephfsplugin:v1.0.0"
         - name: ROOK_CSI_ENABLE_RBD
-          value: "true"
+          value: "` + strconv.FormatBool(settings.EnableRBDDriver) + `"
         - name: ROOK_CSI_RBD_IMAGE
           value: "quay.io/cephcsi/rbdplugin:v1.0.0"
         - name: ROOK_CSI_REGISTRAR_IMAGE
@@ -927,7 +1127,7 @@ subjects:
 
 // GetRookCluster returns rook-cluster manifest
 func (m *CephManifestsMaster) GetRookCluster(settings *ClusterSettings) string {
-	return `apiVersion: ceph.rook.io/v1
+	return renderConfigOverride(settings) + renderDashboardService(settings) + `apiVersion: ` + settings.clusterAPIVersion() + `
 kind: CephCluster
 metadata:
   name: ` + settings.Namespace + `
@@ -947,8 +1147,7 @@ spec:
   rbdMirroring:
     workers: ` + strconv.Itoa(settings.RBDMirrorWorkers) + `
   metadataDevice:
-  storage:
-    useAllNodes: true
+  storage:` + renderStorageSelection(settings) + `
     useAllDevices: ` + strconv.FormatBool(settings.UseAllDevices) + `
     directories:
     - path: ` + settings.DataDirHostPath + /* simulate legacy fallback osd behavior so existing tests still work */ `
@@ -957,17 +1156,178 @@ spec:
     config:
       storeType: "` + settings.StoreType + `"
       databaseSizeMB: "1024"
-      journalSizeMB: "1024"`
+      journalSizeMB: "1024"` + renderEncryptedDevice(settings.EncryptedDevices)
+}
+
+func renderEncryptedDevice(encrypted bool) string {
+	if !encrypted {
+		return ""
+	}
+	return `
+      encryptedDevice: "true"`
+}
+
+// renderDashboardService returns the external dashboard service followed by a document separator, or an empty string
+// if the dashboard is not exposed
+func renderDashboardService(settings *ClusterSettings) string {
+	if settings.DashboardServiceType == "" {
+		return ""
+	}
+	return renderExternalDashboardService(settings.Namespace, settings.DashboardServiceType, dashboardPortHTTPS) + `---
+`
+}
+
+// renderConfigOverride returns the rook-config-override configmap with the config overrides, followed by a document
+// separator. The configmap is created before the cluster so the daemons pick up the settings at their first start.
+// An empty string is returned if there are no overrides.
+func renderConfigOverride(settings *ClusterSettings) string {
+	overrides := settings.configOverrides()
+	if len(overrides) == 0 {
+		return ""
+	}
+	return `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: ` + k8sutil.ConfigOverrideName + `
+  namespace: ` + settings.Namespace + `
+data:
+  ` + k8sutil.ConfigOverrideVal + `: |` + renderCephConfig(overrides, 4) + `
+---
+`
+}
+
+// configOverrides returns the config overrides merged with the recovery throttle settings, which take precedence
+func (s *ClusterSettings) configOverrides() map[string]map[string]string {
+	if s.RecoveryThrottle == nil || len(s.RecoveryThrottle.settings()) == 0 {
+		return s.ConfigOverrides
+	}
+	overrides := map[string]map[string]string{}
+	for section, values := range s.ConfigOverrides {
+		overrides[section] = map[string]string{}
+		for key, value := range values {
+			overrides[section][key] = value
+		}
+	}
+	if overrides["osd"] == nil {
+		overrides["osd"] = map[string]string{}
+	}
+	for key, value := range s.RecoveryThrottle.settings() {
+		overrides["osd"][key] = value
+	}
+	return overrides
+}
+
+// renderCephConfig returns the settings in the ini format of ceph.conf with sorted sections and keys
+func renderCephConfig(overrides map[string]map[string]string, indent int) string {
+	prefix := "\n" + strings.Repeat(" ", indent)
+	sections := make([]string, 0, len(overrides))
+	for section := range overrides {
+		sections = append(sections, section)
+	}
+	sort.Strings(sections)
+
+	result := ""
+	for _, section := range sections {
+		result += prefix + "[" + section + "]"
+		keys := make([]string, 0, len(overrides[section]))
+		for key := range overrides[section] {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			result += prefix + key + " = " + overrides[section][key]
+		}
+	}
+	return result
+}
+
+// renderStorageSelection returns the node selection of the storage section. No node is selected if the osd creation
+// is skipped.
+func renderStorageSelection(settings *ClusterSettings) string {
+	if settings.SkipOSDCreation {
+		return `
+    useAllNodes: false`
+	}
+	return renderStorageNodes(settings.Nodes, settings.NodeLocations, settings.NodeMetadataDevices)
+}
+
+// renderStorageNodes returns the node selection of the storage section. The nodes inherit the storage config of
+// the cluster.
+func renderStorageNodes(nodes []string, locations map[string]map[string]string, metadataDevices map[string]string) string {
+	if len(nodes) == 0 {
+		return `
+    useAllNodes: true`
+	}
+	result := `
+    useAllNodes: false
+    nodes:`
+	for _, node := range nodes {
+		result += `
+    - name: ` + strconv.Quote(node)
+		if location := crushLocation(locations[node]); location != "" {
+			result += `
+      location: ` + strconv.Quote(location)
+		}
+		if device, ok := metadataDevices[node]; ok {
+			result += `
+      config:
+        metadataDevice: ` + strconv.Quote(device)
+		}
+	}
+	return result
+}
+
+// crushLocation returns the location in the "rack=rack1,zone=a" format of the osd config with sorted bucket types
+func crushLocation(location map[string]string) string {
+	types := make([]string, 0, len(location))
+	for bucketType := range location {
+		types = append(types, bucketType)
+	}
+	sort.Strings(types)
+	pairs := make([]string, len(types))
+	for i, bucketType := range types {
+		pairs[i] = bucketType + "=" + location[bucketType]
+	}
+	return strings.Join(pairs, ",")
+}
+
+// renderImagePullSecrets returns the imagePullSecrets list indented by the given number of spaces, or an empty string
+// if there are no secrets
+func renderImagePullSecrets(secrets []string, indent int) string {
+	if len(secrets) == 0 {
+		return ""
+	}
+	prefix := "\n" + strings.Repeat(" ", indent)
+	result := prefix + "imagePullSecrets:"
+	for _, secret := range secrets {
+		result += prefix + "- name: " + secret
+	}
+	return result
+}
+
+// renderMetadata returns the labels and annotations of the metadata section of a resource, omitting empty maps
+func renderMetadata(labels, annotations map[string]string) string {
+	result := ""
+	if len(labels) > 0 {
+		result += `
+  labels:` + renderStringMap(labels, 4)
+	}
+	if len(annotations) > 0 {
+		result += `
+  annotations:` + renderStringMap(annotations, 4)
+	}
+	return result
 }
 
 // GetRookToolBox returns rook-toolbox manifest
-func (m *CephManifestsMaster) GetRookToolBox(namespace string) string {
+func (m *CephManifestsMaster) GetRookToolBox(settings *ToolboxSettings) string {
+	namespace := settings.Namespace
 	return `apiVersion: v1
 kind: Pod
 metadata:
   name: rook-ceph-tools
-  namespace: ` + namespace + `
-spec:
+  namespace: ` + namespace + renderMetadata(settings.Labels, settings.Annotations) + `
+spec:` + renderServiceAccountName(settings.ServiceAccount) + `
   dnsPolicy: ClusterFirstWithHostNet
   containers:
   - name: rook-ceph-tools
@@ -1011,6 +1371,48 @@ spec:
           path: mon-endpoints`
 }
 
+func renderServiceAccountName(name string) string {
+	if name == "" {
+		return ""
+	}
+	return `
+  serviceAccountName: ` + name
+}
+
+// renderToolboxServiceAccount returns the service account of the toolbox with a role binding that allows it to read
+// the mon endpoints and secrets of the cluster
+func renderToolboxServiceAccount(namespace, name string) string {
+	return `apiVersion: v1
+kind: ServiceAccount
+metadata:
+  name: ` + name + `
+  namespace: ` + namespace + `
+---
+kind: Role
+apiVersion: rbac.authorization.k8s.io/v1beta1
+metadata:
+  name: ` + name + `
+  namespace: ` + namespace + `
+rules:
+- apiGroups: [""]
+  resources: ["configmaps", "secrets"]
+  verbs: ["get", "list"]
+---
+kind: RoleBinding
+apiVersion: rbac.authorization.k8s.io/v1beta1
+metadata:
+  name: ` + name + `
+  namespace: ` + namespace + `
+roleRef:
+  apiGroup: rbac.authorization.k8s.io
+  kind: Role
+  name: ` + name + `
+subjects:
+- kind: ServiceAccount
+  name: ` + name + `
+  namespace: ` + namespace
+}
+
 // GetCleanupPod gets a cleanup Pod manifest
 func (m *CephManifestsMaster) GetCleanupPod(node, removalDir string) string {
 	return `apiVersion: batch/v1
@@ -1052,7 +1454,61 @@ spec:
     size: ` + replicaSize
 }
 
+// GetBlockPools returns the manifests of all the pools as a single multi-document yaml
+func (m *CephManifestsMaster) GetBlockPools(namespace string, pools []PoolSpec) string {
+	var docs []string
+	for _, pool := range pools {
+		docs = append(docs, m.getBlockPool(namespace, pool))
+	}
+	return strings.Join(docs, "\n---\n")
+}
+
+func (m *CephManifestsMaster) getBlockPool(namespace string, pool PoolSpec) string {
+	manifest := `apiVersion: ceph.rook.io/v1
+kind: CephBlockPool
+metadata:
+  name: ` + pool.Name + `
+  namespace: ` + namespace + `
+spec:`
+	if pool.FailureDomain != "" {
+		manifest += `
+  failureDomain: ` + pool.FailureDomain
+	}
+	manifest += `
+  replicated:
+    size: ` + strconv.Itoa(pool.Replicas)
+	if pool.MirroringMode != "" {
+		manifest += `
+  mirroring:
+    enabled: true
+    mode: ` + pool.MirroringMode
+	}
+	if parameters := pool.compressionParameters(); len(parameters) > 0 {
+		manifest += `
+  parameters:` + renderStringMap(parameters, 4)
+	}
+	if pool.hasQuota() {
+		manifest += `
+  quotas:`
+		if pool.MaxBytes > 0 {
+			manifest += `
+    maxBytes: ` + strconv.FormatUint(pool.MaxBytes, 10)
+		}
+		if pool.MaxObjects > 0 {
+			manifest += `
+    maxObjects: ` + strconv.FormatUint(pool.MaxObjects, 10)
+		}
+	}
+	return manifest
+}
+
 func (m *CephManifestsMaster) GetBlockStorageClassDef(poolName string, storageClassName string, reclaimPolicy string, namespace string, varClusterName bool) string {
+	return m.GetBlockStorageClassWithMetadata(poolName, storageClassName, reclaimPolicy, namespace, varClusterName, nil, nil)
+}
+
+// GetBlockStorageClassWithMetadata returns the block storage class with the given labels and annotations, such as
+// DefaultStorageClassAnnotation
+func (m *CephManifestsMaster) GetBlockStorageClassWithMetadata(poolName, storageClassName, reclaimPolicy, namespace string, varClusterName bool, labels, annotations map[string]string) string {
 	namespaceParameter := "clusterNamespace"
 	if varClusterName {
 		namespaceParameter = "clusterName"
@@ -1060,7 +1516,7 @@ func (m *CephManifestsMaster) GetBlockStorageClassDef(poolName string, storageCl
 	return `apiVersion: storage.k8s.io/v1
 kind: StorageClass
 metadata:
-   name: ` + storageClassName + `
+  name: ` + storageClassName + renderMetadata(labels, annotations) + `
 provisioner: ceph.rook.io/block
 reclaimPolicy: ` + reclaimPolicy + `
 parameters:
@@ -1094,6 +1550,11 @@ func (m *CephManifestsMaster) GetBlockPoolStorageClass(namespace string, poolNam
 
 // GetFilesystem returns the manifest to create a Rook filesystem resource with the given config.
 func (m *CephManifestsMaster) GetFilesystem(namespace, name string, activeCount int) string {
+	return m.GetFilesystemWithStandby(namespace, name, activeCount, true)
+}
+
+// GetFilesystemWithStandby returns the filesystem manifest with standby-replay of the active mds enabled or disabled
+func (m *CephManifestsMaster) GetFilesystemWithStandby(namespace, name string, activeCount int, activeStandby bool) string {
 	return `apiVersion: ceph.rook.io/v1
 kind: CephFilesystem
 metadata:
@@ -1108,22 +1569,21 @@ spec:
       size: 1
   metadataServer:
     activeCount: ` + strconv.Itoa(activeCount) + `
-    activeStandby: true`
+    activeStandby: ` + strconv.FormatBool(activeStandby)
 }
 
 func (m *CephManifestsMaster) GetObjectStore(namespace, name string, replicaCount, port int) string {
+	return m.GetObjectStoreWithPools(namespace, name, replicaCount, port, defaultObjectPool, defaultObjectPool)
+}
+
+// GetObjectStoreWithPools returns the object store with the given config of the metadata and data pools
+func (m *CephManifestsMaster) GetObjectStoreWithPools(namespace, name string, replicaCount, port int, metadataPool, dataPool ObjectPoolSpec) string {
 	return `apiVersion: ceph.rook.io/v1
 kind: CephObjectStore
 metadata:
   name: ` + name + `
   namespace: ` + namespace + `
-spec:
-  metadataPool:
-    replicated:
-      size: 1
-  dataPool:
-    replicated:
-      size: 1
+spec:` + renderObjectPool("metadataPool", metadataPool) + renderObjectPool("dataPool", dataPool) + `
   gateway:
     type: s3
     sslCertificateRef:
@@ -1144,3 +1604,8 @@ spec:
   displayName: ` + displayName + `
   store: ` + store
 }
+
+// GetObjectStoreWithDNSNames returns the object store that serves virtual-hosted-style requests for the dns names
+func (m *CephManifestsMaster) GetObjectStoreWithDNSNames(namespace, name string, replicaCount, port int, dnsNames []string) string {
+	return strings.TrimSuffix(m.GetObjectStore(namespace, name, replicaCount, port), "\n") + renderObjectStoreHosting(dnsNames)
+}
@@ -0,0 +1,523 @@
+/*
+Copyright 2019 The Rook Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package installer
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ghodss/yaml"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func testClusterSettings() *ClusterSettings {
+	return &ClusterSettings{
+		Namespace:        "rook-ceph",
+		StoreType:        "bluestore",
+		DataDirHostPath:  "/var/lib/rook",
+		Mons:             3,
+		RBDMirrorWorkers: 1,
+		CephVersion:      MimicVersion,
+	}
+}
+
+// parseManifest unmarshals a single yaml document into a generic map
+func parseManifest(t *testing.T, manifest string) map[string]interface{} {
+	var result map[string]interface{}
+	require.Nil(t, yaml.Unmarshal([]byte(manifest), &result), manifest)
+	return result
+}
+
+// parseManifests unmarshals each document of a multi-document yaml
+func parseManifests(t *testing.T, manifests string) []map[string]interface{} {
+	var result []map[string]interface{}
+	for _, doc := range strings.Split(manifests, "\n---\n") {
+		result = append(result, parseManifest(t, doc))
+	}
+	return result
+}
+
+// findManifest returns the parsed document with the given kind and name
+func findManifest(t *testing.T, manifests, kind, name string) map[string]interface{} {
+	for _, doc := range parseManifests(t, manifests) {
+		if doc == nil || doc["kind"] != kind {
+			continue
+		}
+		if doc["metadata"].(map[string]interface{})["name"] == name {
+			return doc
+		}
+	}
+	require.Fail(t, "manifest not found", "%s %s", kind, name)
+	return nil
+}
+
+// getContainerEnv returns the env vars of the first container of a deployment
+func getContainerEnv(t *testing.T, deployment map[string]interface{}) map[string]interface{} {
+	template := deployment["spec"].(map[string]interface{})["template"].(map[string]interface{})
+	containers := template["spec"].(map[string]interface{})["containers"].([]interface{})
+	require.True(t, len(containers) > 0)
+
+	env := map[string]interface{}{}
+	for _, v := range containers[0].(map[string]interface{})["env"].([]interface{}) {
+		envVar := v.(map[string]interface{})
+		env[envVar["name"].(string)] = envVar["value"]
+	}
+	return env
+}
+
+// getSpec returns the spec section of the parsed manifest
+func getSpec(t *testing.T, manifest string) map[string]interface{} {
+	spec, ok := parseManifest(t, manifest)["spec"].(map[string]interface{})
+	require.True(t, ok, manifest)
+	return spec
+}
+
+func TestClusterManifestDefaults(t *testing.T) {
+	m := &CephManifestsMaster{imageTag: VersionMaster}
+	spec := getSpec(t, m.GetRookCluster(testClusterSettings()))
+
+	assert.Equal(t, "/var/lib/rook", spec["dataDirHostPath"])
+	storage := spec["storage"].(map[string]interface{})
+	assert.Equal(t, true, storage["useAllNodes"])
+	_, ok := storage["nodes"]
+	assert.False(t, ok)
+}
+
+func TestClusterManifestEncryptedDevices(t *testing.T) {
+	m := &CephManifestsMaster{imageTag: VersionMaster}
+	settings := testClusterSettings()
+	config := getSpec(t, m.GetRookCluster(settings))["storage"].(map[string]interface{})["config"].(map[string]interface{})
+	_, ok := config["encryptedDevice"]
+	assert.False(t, ok)
+
+	settings.EncryptedDevices = true
+	config = getSpec(t, m.GetRookCluster(settings))["storage"].(map[string]interface{})["config"].(map[string]interface{})
+	assert.Equal(t, "true", config["encryptedDevice"])
+	assert.Equal(t, "bluestore", config["storeType"])
+}
+
+func TestClusterManifestStorageNodes(t *testing.T) {
+	m := &CephManifestsMaster{imageTag: VersionMaster}
+	settings := testClusterSettings()
+	settings.Nodes = []string{"node1", "node2"}
+	storage := getSpec(t, m.GetRookCluster(settings))["storage"].(map[string]interface{})
+
+	assert.Equal(t, false, storage["useAllNodes"])
+	assert.Equal(t, []interface{}{
+		map[string]interface{}{"name": "node1"},
+		map[string]interface{}{"name": "node2"},
+	}, storage["nodes"])
+	// the nodes inherit the cluster level storage config
+	assert.Equal(t, false, storage["useAllDevices"])
+	assert.NotNil(t, storage["directories"])
+}
+
+func TestClusterManifestNodeLocations(t *testing.T) {
+	m := &CephManifestsMaster{imageTag: VersionMaster}
+	settings := testClusterSettings()
+	settings.Nodes = []string{"node1", "node2"}
+	settings.NodeLocations = map[string]map[string]string{
+		"node1": {"rack": "rack1", "zone": "a"},
+		"node3": {"rack": "rack3"},
+	}
+	storage := getSpec(t, m.GetRookCluster(settings))["storage"].(map[string]interface{})
+
+	assert.Equal(t, []interface{}{
+		map[string]interface{}{"name": "node1", "location": "rack=rack1,zone=a"},
+		map[string]interface{}{"name": "node2"},
+	}, storage["nodes"])
+}
+
+func TestClusterManifestNodeMetadataDevices(t *testing.T) {
+	m := &CephManifestsMaster{imageTag: VersionMaster}
+	settings := testClusterSettings()
+	settings.Nodes = []string{"node1", "node2"}
+	settings.NodeLocations = map[string]map[string]string{"node1": {"rack": "rack1"}}
+	settings.NodeMetadataDevices = map[string]string{"node1": "nvme0n1", "node3": "nvme1n1"}
+	storage := getSpec(t, m.GetRookCluster(settings))["storage"].(map[string]interface{})
+
+	assert.Equal(t, []interface{}{
+		map[string]interface{}{"name": "node1", "location": "rack=rack1", "config": map[string]interface{}{"metadataDevice": "nvme0n1"}},
+		map[string]interface{}{"name": "node2"},
+	}, storage["nodes"])
+}
+
+func TestClusterManifestAPIVersion(t *testing.T) {
+	m := &CephManifestsMaster{imageTag: VersionMaster}
+	settings := testClusterSettings()
+	assert.Equal(t, "ceph.rook.io/v1", parseManifest(t, m.GetRookCluster(settings))["apiVersion"])
+
+	settings.APIVersion = "ceph.rook.io/v2"
+	cluster := parseManifest(t, m.GetRookCluster(settings))
+	assert.Equal(t, "ceph.rook.io/v2", cluster["apiVersion"])
+	assert.Equal(t, "CephCluster", cluster["kind"])
+}
+
+func TestClusterManifestConfigOverrides(t *testing.T) {
+	m := &CephManifestsMaster{imageTag: VersionMaster}
+	settings := testClusterSettings()
+	settings.ConfigOverrides = map[string]map[string]string{
+		"osd":    {"osd max backfills": "2", "osd crush update on start": "false"},
+		"global": {"osd pool default size": "1"},
+	}
+	docs := parseManifests(t, m.GetRookCluster(settings))
+	require.Equal(t, 2, len(docs))
+
+	assert.Equal(t, "ConfigMap", docs[0]["kind"])
+	metadata := docs[0]["metadata"].(map[string]interface{})
+	assert.Equal(t, "rook-config-override", metadata["name"])
+	assert.Equal(t, "rook-ceph", metadata["namespace"])
+	expected := "[global]\nosd pool default size = 1\n[osd]\nosd crush update on start = false\nosd max backfills = 2"
+	assert.Equal(t, expected, docs[0]["data"].(map[string]interface{})["config"])
+
+	assert.Equal(t, "CephCluster", docs[1]["kind"])
+	assert.Equal(t, "/var/lib/rook", docs[1]["spec"].(map[string]interface{})["dataDirHostPath"])
+}
+
+func TestClusterManifestRecoveryThrottle(t *testing.T) {
+	m := &CephManifestsMaster{imageTag: VersionMaster}
+	settings := testClusterSettings()
+	settings.RecoveryThrottle = &RecoveryThrottle{}
+	require.Equal(t, 1, len(parseManifests(t, m.GetRookCluster(settings))))
+
+	settings.RecoveryThrottle = &RecoveryThrottle{MaxBackfills: 4, RecoveryMaxActive: 8}
+	config := findManifest(t, m.GetRookCluster(settings), "ConfigMap", "rook-config-override")["data"].(map[string]interface{})["config"]
+	assert.Equal(t, "[osd]\nosd_max_backfills = 4\nosd_recovery_max_active = 8", config)
+
+	// the throttle is merged with the other overrides without changing them
+	settings.ConfigOverrides = map[string]map[string]string{"osd": {"osd_max_backfills": "1", "osd crush update on start": "false"}}
+	config = findManifest(t, m.GetRookCluster(settings), "ConfigMap", "rook-config-override")["data"].(map[string]interface{})["config"]
+	assert.Equal(t, "[osd]\nosd crush update on start = false\nosd_max_backfills = 4\nosd_recovery_max_active = 8", config)
+	assert.Equal(t, "1", settings.ConfigOverrides["osd"]["osd_max_backfills"])
+}
+
+func TestBlockPoolsManifest(t *testing.T) {
+	m := &CephManifestsMaster{imageTag: VersionMaster}
+	pools := []PoolSpec{
+		{Name: "pool1", Replicas: 1},
+		{Name: "pool2", Replicas: 2, FailureDomain: "osd"},
+		{Name: "pool3", Replicas: 3, FailureDomain: "host"},
+	}
+	docs := parseManifests(t, m.GetBlockPools("rook-ceph", pools))
+	require.Equal(t, 3, len(docs))
+
+	for i, doc := range docs {
+		assert.Equal(t, "CephBlockPool", doc["kind"])
+		metadata := doc["metadata"].(map[string]interface{})
+		assert.Equal(t, pools[i].Name, metadata["name"])
+		assert.Equal(t, "rook-ceph", metadata["namespace"])
+
+		spec := doc["spec"].(map[string]interface{})
+		replicated := spec["replicated"].(map[string]interface{})
+		assert.Equal(t, float64(pools[i].Replicas), replicated["size"])
+		if pools[i].FailureDomain == "" {
+			_, ok := spec["failureDomain"]
+			assert.False(t, ok)
+		} else {
+			assert.Equal(t, pools[i].FailureDomain, spec["failureDomain"])
+		}
+	}
+}
+
+func TestBlockPoolsManifestCompression(t *testing.T) {
+	m := &CephManifestsMaster{imageTag: VersionMaster}
+	pools := []PoolSpec{
+		{Name: "plain", Replicas: 1},
+		{Name: "compressed", Replicas: 1, CompressionMode: "aggressive", CompressionAlgorithm: "zstd"},
+		{Name: "passive", Replicas: 1, CompressionMode: "passive"},
+	}
+	docs := parseManifests(t, m.GetBlockPools("rook-ceph", pools))
+	require.Equal(t, 3, len(docs))
+
+	_, ok := docs[0]["spec"].(map[string]interface{})["parameters"]
+	assert.False(t, ok)
+
+	spec := docs[1]["spec"].(map[string]interface{})
+	assert.Equal(t, map[string]interface{}{"compression_mode": "aggressive", "compression_algorithm": "zstd"}, spec["parameters"])
+	assert.Equal(t, float64(1), spec["replicated"].(map[string]interface{})["size"])
+
+	spec = docs[2]["spec"].(map[string]interface{})
+	assert.Equal(t, map[string]interface{}{"compression_mode": "passive"}, spec["parameters"])
+}
+
+func TestBlockPoolsManifestMirroring(t *testing.T) {
+	m := &CephManifestsMaster{imageTag: VersionMaster}
+	pools := []PoolSpec{
+		{Name: "plain", Replicas: 1},
+		{Name: "image", Replicas: 1, MirroringMode: "image"},
+		{Name: "pool", Replicas: 1, MirroringMode: "pool"},
+	}
+	docs := parseManifests(t, m.GetBlockPools("rook-ceph", pools))
+	require.Equal(t, 3, len(docs))
+
+	_, ok := docs[0]["spec"].(map[string]interface{})["mirroring"]
+	assert.False(t, ok)
+	assert.Equal(t, map[string]interface{}{"enabled": true, "mode": "image"}, docs[1]["spec"].(map[string]interface{})["mirroring"])
+	assert.Equal(t, map[string]interface{}{"enabled": true, "mode": "pool"}, docs[2]["spec"].(map[string]interface{})["mirroring"])
+}
+
+func TestObjectStoreManifestPools(t *testing.T) {
+	m := &CephManifestsMaster{imageTag: VersionMaster}
+	spec := getSpec(t, m.GetObjectStore("rook-ceph", "store", 1, 80))
+	replicated := map[string]interface{}{"replicated": map[string]interface{}{"size": float64(1)}}
+	assert.Equal(t, replicated, spec["metadataPool"])
+	assert.Equal(t, replicated, spec["dataPool"])
+	assert.Equal(t, float64(80), spec["gateway"].(map[string]interface{})["port"])
+
+	metadataPool := ObjectPoolSpec{Replicas: 3, FailureDomain: "host"}
+	dataPool := ObjectPoolSpec{DataChunks: 2, CodingChunks: 1, FailureDomain: "osd"}
+	spec = getSpec(t, m.GetObjectStoreWithPools("rook-ceph", "store", 1, 80, metadataPool, dataPool))
+	assert.Equal(t, map[string]interface{}{
+		"failureDomain": "host",
+		"replicated":    map[string]interface{}{"size": float64(3)},
+	}, spec["metadataPool"])
+	assert.Equal(t, map[string]interface{}{
+		"failureDomain": "osd",
+		"erasureCoded":  map[string]interface{}{"dataChunks": float64(2), "codingChunks": float64(1)},
+	}, spec["dataPool"])
+	assert.Equal(t, "s3", spec["gateway"].(map[string]interface{})["type"])
+}
+
+func TestFilesystemManifestStandby(t *testing.T) {
+	m := &CephManifestsMaster{imageTag: VersionMaster}
+	metadataServer := getSpec(t, m.GetFilesystem("rook-ceph", "myfs", 2))["metadataServer"].(map[string]interface{})
+	assert.Equal(t, float64(2), metadataServer["activeCount"])
+	assert.Equal(t, true, metadataServer["activeStandby"])
+
+	metadataServer = getSpec(t, m.GetFilesystemWithStandby("rook-ceph", "myfs", 1, false))["metadataServer"].(map[string]interface{})
+	assert.Equal(t, float64(1), metadataServer["activeCount"])
+	assert.Equal(t, false, metadataServer["activeStandby"])
+
+	// one standby is started for each active mds
+	assert.Equal(t, 2, MDSPodCount(1))
+	assert.Equal(t, 4, MDSPodCount(2))
+}
+
+func TestToolboxManifestMetadata(t *testing.T) {
+	m := &CephManifestsMaster{imageTag: VersionMaster}
+	metadata := parseManifest(t, m.GetRookToolBox(&ToolboxSettings{Namespace: "rook-ceph"}))["metadata"].(map[string]interface{})
+	assert.Equal(t, "rook-ceph-tools", metadata["name"])
+	_, ok := metadata["labels"]
+	assert.False(t, ok)
+	_, ok = metadata["annotations"]
+	assert.False(t, ok)
+
+	settings := &ToolboxSettings{
+		Namespace:   "rook-ceph",
+		Labels:      map[string]string{"team": "storage"},
+		Annotations: map[string]string{"scanner.example.com/scan": "true", "seccomp.security.alpha.kubernetes.io/pod": "runtime/default"},
+	}
+	toolbox := parseManifest(t, m.GetRookToolBox(settings))
+	metadata = toolbox["metadata"].(map[string]interface{})
+	assert.Equal(t, map[string]interface{}{"team": "storage"}, metadata["labels"])
+	assert.Equal(t, map[string]interface{}{
+		"scanner.example.com/scan":                 "true",
+		"seccomp.security.alpha.kubernetes.io/pod": "runtime/default",
+	}, metadata["annotations"])
+	assert.NotNil(t, toolbox["spec"].(map[string]interface{})["containers"])
+}
+
+func TestToolboxManifestServiceAccount(t *testing.T) {
+	m := &CephManifestsMaster{imageTag: VersionMaster}
+	spec := getSpec(t, m.GetRookToolBox(&ToolboxSettings{Namespace: "rook-ceph"}))
+	_, ok := spec["serviceAccountName"]
+	assert.False(t, ok)
+
+	spec = getSpec(t, m.GetRookToolBox(&ToolboxSettings{Namespace: "rook-ceph", ServiceAccount: "rook-ceph-tools"}))
+	assert.Equal(t, "rook-ceph-tools", spec["serviceAccountName"])
+	assert.Equal(t, "ClusterFirstWithHostNet", spec["dnsPolicy"])
+
+	account := renderToolboxServiceAccount("rook-ceph", "rook-ceph-tools")
+	require.Equal(t, 3, len(parseManifests(t, account)))
+	binding := findManifest(t, account, "RoleBinding", "rook-ceph-tools")
+	assert.Equal(t, "rook-ceph-tools", binding["roleRef"].(map[string]interface{})["name"])
+	assert.Equal(t, []interface{}{map[string]interface{}{"kind": "ServiceAccount", "name": "rook-ceph-tools", "namespace": "rook-ceph"}}, binding["subjects"])
+}
+
+func TestOperatorManifestCSIDrivers(t *testing.T) {
+	m := &CephManifestsMaster{imageTag: VersionMaster}
+	settings := &OperatorSettings{Namespace: "rook-ceph-system", EnableRBDDriver: true, EnableCephFSDriver: true}
+	env := getContainerEnv(t, findManifest(t, m.GetRookOperator(settings), "Deployment", "rook-ceph-operator"))
+	assert.Equal(t, "true", env["ROOK_CSI_ENABLE_RBD"])
+	assert.Equal(t, "true", env["ROOK_CSI_ENABLE_CEPHFS"])
+
+	settings.EnableCephFSDriver = false
+	env = getContainerEnv(t, findManifest(t, m.GetRookOperator(settings), "Deployment", "rook-ceph-operator"))
+	assert.Equal(t, "true", env["ROOK_CSI_ENABLE_RBD"])
+	assert.Equal(t, "false", env["ROOK_CSI_ENABLE_CEPHFS"])
+
+	settings.EnableRBDDriver = false
+	env = getContainerEnv(t, findManifest(t, m.GetRookOperator(settings), "Deployment", "rook-ceph-operator"))
+	assert.Equal(t, "false", env["ROOK_CSI_ENABLE_RBD"])
+	assert.Equal(t, "false", env["ROOK_CSI_ENABLE_CEPHFS"])
+}
+
+func TestBlockStorageClassMetadata(t *testing.T) {
+	m := &CephManifestsMaster{imageTag: VersionMaster}
+	sc := parseManifest(t, m.GetBlockStorageClassDef("replicapool", "rook-ceph-block", "Delete", "rook-ceph", false))
+	metadata := sc["metadata"].(map[string]interface{})
+	assert.Equal(t, map[string]interface{}{"name": "rook-ceph-block"}, metadata)
+	assert.Equal(t, map[string]interface{}{"blockPool": "replicapool", "clusterNamespace": "rook-ceph"}, sc["parameters"])
+
+	labels := map[string]string{"app.kubernetes.io/managed-by": "gitops"}
+	annotations := map[string]string{DefaultStorageClassAnnotation: "true"}
+	sc = parseManifest(t, m.GetBlockStorageClassWithMetadata("replicapool", "rook-ceph-block", "Delete", "rook-ceph", true, labels, annotations))
+	metadata = sc["metadata"].(map[string]interface{})
+	assert.Equal(t, "rook-ceph-block", metadata["name"])
+	assert.Equal(t, map[string]interface{}{"app.kubernetes.io/managed-by": "gitops"}, metadata["labels"])
+	assert.Equal(t, map[string]interface{}{"storageclass.kubernetes.io/is-default-class": "true"}, metadata["annotations"])
+	assert.Equal(t, "rook-ceph", sc["parameters"].(map[string]interface{})["clusterName"])
+}
+
+func TestObjectStorePoolDeviceClass(t *testing.T) {
+	m := &CephManifestsMaster{imageTag: VersionMaster}
+	spec := getSpec(t, m.GetObjectStoreWithPools("rook-ceph", "store-a", 1, 80,
+		ObjectPoolSpec{Replicas: 3, DeviceClass: "nvme"}, ObjectPoolSpec{DataChunks: 2, CodingChunks: 1, DeviceClass: "hdd"}))
+	assert.Equal(t, map[string]interface{}{"deviceClass": "nvme", "replicated": map[string]interface{}{"size": float64(3)}}, spec["metadataPool"])
+	assert.Equal(t, "hdd", spec["dataPool"].(map[string]interface{})["deviceClass"])
+
+	spec = getSpec(t, m.GetObjectStore("rook-ceph", "store-a", 1, 80))
+	assert.Nil(t, spec["metadataPool"].(map[string]interface{})["deviceClass"])
+}
+
+func TestObjectStoreDNSNames(t *testing.T) {
+	m := &CephManifestsMaster{imageTag: VersionMaster}
+	spec := getSpec(t, m.GetObjectStoreWithDNSNames("rook-ceph", "store-a", 1, 80, []string{"s3.example.com", "rgw.example.com"}))
+	assert.Equal(t, map[string]interface{}{"dnsNames": []interface{}{"s3.example.com", "rgw.example.com"}}, spec["hosting"])
+	assert.Equal(t, float64(80), spec["gateway"].(map[string]interface{})["port"])
+
+	spec = getSpec(t, m.GetObjectStoreWithDNSNames("rook-ceph", "store-a", 1, 80, nil))
+	assert.Nil(t, spec["hosting"])
+}
+
+func TestClusterManifestSkipOSDCreation(t *testing.T) {
+	settings := testClusterSettings()
+	settings.Nodes = []string{"node1"}
+	settings.SkipOSDCreation = true
+	for _, m := range []CephManifests{&CephManifestsMaster{imageTag: VersionMaster}, &CephManifestsV0_9{imageTag: Version0_9}} {
+		storage := getSpec(t, m.GetRookCluster(settings))["storage"].(map[string]interface{})
+		assert.Equal(t, false, storage["useAllNodes"])
+		assert.Nil(t, storage["nodes"])
+	}
+
+	settings.SkipOSDCreation = false
+	storage := getSpec(t, (&CephManifestsMaster{imageTag: VersionMaster}).GetRookCluster(settings))["storage"].(map[string]interface{})
+	assert.Len(t, storage["nodes"], 1)
+}
+
+func TestClusterManifestDashboardService(t *testing.T) {
+	m := &CephManifestsMaster{imageTag: VersionMaster}
+	settings := testClusterSettings()
+	require.Equal(t, 1, len(parseManifests(t, m.GetRookCluster(settings))))
+
+	settings.DashboardServiceType = "LoadBalancer"
+	manifests := m.GetRookCluster(settings)
+	require.Equal(t, 2, len(parseManifests(t, manifests)))
+	svc := findManifest(t, manifests, "Service", "rook-ceph-mgr-dashboard-external")
+	spec := svc["spec"].(map[string]interface{})
+	assert.Equal(t, "LoadBalancer", spec["type"])
+	assert.Equal(t, float64(8443), spec["ports"].([]interface{})[0].(map[string]interface{})["port"])
+	assert.Equal(t, map[string]interface{}{"app": "rook-ceph-mgr", "rook_cluster": "rook-ceph"}, spec["selector"])
+}
+
+func TestOperatorIsolationPolicyManifest(t *testing.T) {
+	policy := parseManifest(t, renderOperatorIsolationPolicy("rook-ceph-system"))
+	assert.Equal(t, "NetworkPolicy", policy["kind"])
+	assert.Equal(t, map[string]interface{}{"name": "rook-ceph-operator-isolation", "namespace": "rook-ceph-system"}, policy["metadata"])
+	assert.Equal(t, map[string]interface{}{
+		"podSelector": map[string]interface{}{"matchLabels": map[string]interface{}{"app": "rook-ceph-operator"}},
+		"policyTypes": []interface{}{"Egress"},
+	}, policy["spec"])
+}
+
+func TestBlockPoolsManifestQuotas(t *testing.T) {
+	m := &CephManifestsMaster{imageTag: VersionMaster}
+	pools := []PoolSpec{
+		{Name: "plain", Replicas: 1},
+		{Name: "bytes", Replicas: 1, MaxBytes: 10485760},
+		{Name: "both", Replicas: 1, MaxBytes: 1048576, MaxObjects: 100},
+	}
+	docs := parseManifests(t, m.GetBlockPools("rook-ceph", pools))
+	require.Equal(t, 3, len(docs))
+
+	_, ok := docs[0]["spec"].(map[string]interface{})["quotas"]
+	assert.False(t, ok)
+	assert.Equal(t, map[string]interface{}{"maxBytes": float64(10485760)}, docs[1]["spec"].(map[string]interface{})["quotas"])
+	assert.Equal(t, map[string]interface{}{"maxBytes": float64(1048576), "maxObjects": float64(100)}, docs[2]["spec"].(map[string]interface{})["quotas"])
+}
+
+func TestNodeCommandPodManifest(t *testing.T) {
+	pod := parseManifest(t, renderNodeCommandPod("rook-ceph", "node1", "format-sdb", "rook/ceph:master", []string{"mkfs.ext4", "-F", "/dev/sdb"}))
+	assert.Equal(t, "Pod", pod["kind"])
+	spec := pod["spec"].(map[string]interface{})
+	assert.Equal(t, "node1", spec["nodeName"])
+	assert.Equal(t, "Never", spec["restartPolicy"])
+	container := spec["containers"].([]interface{})[0].(map[string]interface{})
+	assert.Equal(t, "rook/ceph:master", container["image"])
+	assert.Equal(t, []interface{}{"mkfs.ext4", "-F", "/dev/sdb"}, container["command"])
+	assert.Equal(t, map[string]interface{}{"privileged": true}, container["securityContext"])
+}
+
+func TestOperatorManifestDiscoverDevicesInterval(t *testing.T) {
+	for _, m := range []CephManifests{&CephManifestsMaster{imageTag: VersionMaster}, &CephManifestsV0_9{imageTag: "v0.9.0"}} {
+		settings := &OperatorSettings{Namespace: "rook-ceph-system"}
+		env := getContainerEnv(t, findManifest(t, m.GetRookOperator(settings), "Deployment", "rook-ceph-operator"))
+		_, ok := env["ROOK_DISCOVER_DEVICES_INTERVAL"]
+		assert.False(t, ok)
+
+		settings.DiscoverDevicesInterval = 30 * time.Second
+		env = getContainerEnv(t, findManifest(t, m.GetRookOperator(settings), "Deployment", "rook-ceph-operator"))
+		assert.Equal(t, "30s", env["ROOK_DISCOVER_DEVICES_INTERVAL"])
+	}
+}
+
+func TestClusterManifestTopologyLocations(t *testing.T) {
+	m := &CephManifestsMaster{imageTag: VersionMaster}
+	nodes := []v1.Node{
+		{ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"kubernetes.io/hostname": "node1", "topology.kubernetes.io/zone": "a"}}},
+		{ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"kubernetes.io/hostname": "node2", "topology.kubernetes.io/zone": "b"}}},
+	}
+	locations, err := topologyLocations(nodes, "topology.kubernetes.io/zone", map[string]map[string]string{"node1": {"rack": "rack1"}})
+	require.Nil(t, err)
+
+	settings := testClusterSettings()
+	settings.Nodes = []string{"node1", "node2"}
+	settings.NodeLocations = locations
+	storage := getSpec(t, m.GetRookCluster(settings))["storage"].(map[string]interface{})
+	assert.Equal(t, []interface{}{
+		map[string]interface{}{"name": "node1", "location": "rack=rack1,zone=a"},
+		map[string]interface{}{"name": "node2", "location": "zone=b"},
+	}, storage["nodes"])
+}
+
+func TestImagePullSecretsManifests(t *testing.T) {
+	expected := []interface{}{map[string]interface{}{"name": "registry-a"}, map[string]interface{}{"name": "registry-b"}}
+	for _, m := range []CephManifests{&CephManifestsMaster{imageTag: VersionMaster}, &CephManifestsV0_9{imageTag: "v0.9.0"}} {
+		settings := &OperatorSettings{Namespace: "rook-ceph-system"}
+		deployment := findManifest(t, m.GetRookOperator(settings), "Deployment", "rook-ceph-operator")
+		podSpec := deployment["spec"].(map[string]interface{})["template"].(map[string]interface{})["spec"].(map[string]interface{})
+		assert.Nil(t, podSpec["imagePullSecrets"])
+
+		settings.ImagePullSecrets = []string{"registry-a", "registry-b"}
+		deployment = findManifest(t, m.GetRookOperator(settings), "Deployment", "rook-ceph-operator")
+		podSpec = deployment["spec"].(map[string]interface{})["template"].(map[string]interface{})["spec"].(map[string]interface{})
+		assert.Equal(t, expected, podSpec["imagePullSecrets"])
+	}
+}
@@ -18,6 +18,7 @@ package installer
 
 import (
 	"strconv"
+	"strings"
 
 	"github.com/google/uuid"
 )
@@ -186,7 +187,8 @@ spec:
 }
 
 // GetRookOperator returns rook Operator manifest
-func (m *CephManifestsV0_9) GetRookOperator(namespace string) string {
+func (m *CephManifestsV0_9) GetRookOperator(settings *OperatorSettings) string {
+	namespace := settings.Namespace
 	return `kind: Namespace
 apiVersion: v1
 metadata:
@@ -418,7 +420,7 @@ spec:
       labels:
         app: rook-ceph-operator
     spec:
-      serviceAccountName: rook-ceph-system
+      serviceAccountName: rook-ceph-system` + renderImagePullSecrets(settings.ImagePullSecrets, 6) + `
       containers:
       - name: rook-ceph-operator
         image: rook/ceph:` + m.imageTag + `
@@ -429,7 +431,7 @@ spec:
         - name: ROOK_MON_HEALTHCHECK_INTERVAL
           value: "10s"
         - name: ROOK_MON_OUT_TIMEOUT
-          value: "15s"
+          value: "15s"` + renderDiscoverDevicesInterval(settings.DiscoverDevicesInterval) + `
         - name: NODE_NAME
           valueFrom:
             fieldRef:
@@ -598,7 +600,7 @@ subjects:
 
 // GetRookCluster returns rook-cluster manifest
 func (m *CephManifestsV0_9) GetRookCluster(settings *ClusterSettings) string {
-	return `apiVersion: ceph.rook.io/v1
+	return renderConfigOverride(settings) + `apiVersion: ` + settings.clusterAPIVersion() + `
 kind: CephCluster
 metadata:
   name: ` + settings.Namespace + `
@@ -618,8 +620,7 @@ spec:
   rbdMirroring:
     workers: ` + strconv.Itoa(settings.RBDMirrorWorkers) + `
   metadataDevice:
-  storage:
-    useAllNodes: true
+  storage:` + renderStorageSelection(settings) + `
     useAllDevices: ` + strconv.FormatBool(settings.UseAllDevices) + `
     deviceFilter:
     location:
@@ -630,13 +631,14 @@ spec:
 }
 
 // GetRookToolBox returns rook-toolbox manifest
-func (m *CephManifestsV0_9) GetRookToolBox(namespace string) string {
+func (m *CephManifestsV0_9) GetRookToolBox(settings *ToolboxSettings) string {
+	namespace := settings.Namespace
 	return `apiVersion: v1
 kind: Pod
 metadata:
   name: rook-ceph-tools
-  namespace: ` + namespace + `
-spec:
+  namespace: ` + namespace + renderMetadata(settings.Labels, settings.Annotations) + `
+spec:` + renderServiceAccountName(settings.ServiceAccount) + `
   dnsPolicy: ClusterFirstWithHostNet
   containers:
   - name: rook-ceph-tools
@@ -721,7 +723,23 @@ spec:
     size: ` + replicaSize
 }
 
+// GetBlockPools returns the manifests of all the pools as a single multi-document yaml. Only the replica count
+// is rendered for v0.9.
+func (m *CephManifestsV0_9) GetBlockPools(namespace string, pools []PoolSpec) string {
+	var docs []string
+	for _, pool := range pools {
+		docs = append(docs, m.GetBlockPoolDef(pool.Name, namespace, strconv.Itoa(pool.Replicas)))
+	}
+	return strings.Join(docs, "\n---\n")
+}
+
 func (m *CephManifestsV0_9) GetBlockStorageClassDef(poolName string, storageClassName string, reclaimPolicy string, namespace string, varClusterName bool) string {
+	return m.GetBlockStorageClassWithMetadata(poolName, storageClassName, reclaimPolicy, namespace, varClusterName, nil, nil)
+}
+
+// GetBlockStorageClassWithMetadata returns the block storage class with the given labels and annotations, such as
+// DefaultStorageClassAnnotation
+func (m *CephManifestsV0_9) GetBlockStorageClassWithMetadata(poolName, storageClassName, reclaimPolicy, namespace string, varClusterName bool, labels, annotations map[string]string) string {
 	namespaceParameter := "clusterNamespace"
 	if varClusterName {
 		namespaceParameter = "clusterName"
@@ -729,7 +747,7 @@ func (m *CephManifestsV0_9) GetBlockStorageClassDef(poolName string, storageClas
 	return `apiVersion: storage.k8s.io/v1
 kind: StorageClass
 metadata:
-   name: ` + storageClassName + `
+  name: ` + storageClassName + renderMetadata(labels, annotations) + `
 provisioner: ceph.rook.io/block
 reclaimPolicy: ` + reclaimPolicy + `
 parameters:
@@ -763,6 +781,11 @@ func (m *CephManifestsV0_9) GetBlockPoolStorageClass(namespace string, poolName
 
 // GetFilesystem returns the manifest to create a Rook filesystem resource with the given config.
 func (m *CephManifestsV0_9) GetFilesystem(namespace, name string, activeCount int) string {
+	return m.GetFilesystemWithStandby(namespace, name, activeCount, true)
+}
+
+// GetFilesystemWithStandby returns the filesystem manifest with standby-replay of the active mds enabled or disabled
+func (m *CephManifestsV0_9) GetFilesystemWithStandby(namespace, name string, activeCount int, activeStandby bool) string {
 	return `apiVersion: ceph.rook.io/v1
 kind: CephFilesystem
 metadata:
@@ -777,22 +800,21 @@ spec:
       size: 1
   metadataServer:
     activeCount: ` + strconv.Itoa(activeCount) + `
-    activeStandby: true`
+    activeStandby: ` + strconv.FormatBool(activeStandby)
 }
 
 func (m *CephManifestsV0_9) GetObjectStore(namespace, name string, replicaCount, port int) string {
+	return m.GetObjectStoreWithPools(namespace, name, replicaCount, port, defaultObjectPool, defaultObjectPool)
+}
+
+// GetObjectStoreWithPools returns the object store with the given config of the metadata and data pools
+func (m *CephManifestsV0_9) GetObjectStoreWithPools(namespace, name string, replicaCount, port int, metadataPool, dataPool ObjectPoolSpec) string {
 	return `apiVersion: ceph.rook.io/v1
 kind: CephObjectStore
 metadata:
   name: ` + name + `
   namespace: ` + namespace + `
-spec:
-  metadataPool:
-    replicated:
-      size: 1
-  dataPool:
-    replicated:
-      size: 1
+spec:` + renderObjectPool("metadataPool", metadataPool) + renderObjectPool("dataPool", dataPool) + `
   gateway:
     type: s3
     sslCertificateRef:
@@ -813,3 +835,8 @@ spec:
   displayName: ` + displayName + `
   store: ` + store
 }
+
+// GetObjectStoreWithDNSNames returns the object store that serves virtual-hosted-style requests for the dns names
+func (m *CephManifestsV0_9) GetObjectStoreWithDNSNames(namespace, name string, replicaCount, port int, dnsNames []string) string {
+	return strings.TrimSuffix(m.GetObjectStore(namespace, name, replicaCount, port), "\n") + renderObjectStoreHosting(dnsNames)
+}
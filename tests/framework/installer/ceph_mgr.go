@@ -0,0 +1,231 @@
+/*
+Copyright 2019 The Rook Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package installer
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/rook/rook/pkg/daemon/ceph/client"
+	"github.com/rook/rook/tests/framework/utils"
+)
+
+const (
+	// BalancerModeUpmap moves individual pgs with the upmap exception table
+	BalancerModeUpmap = "upmap"
+	// BalancerModeCrushCompat adjusts the weights in a compat weight-set
+	BalancerModeCrushCompat = "crush-compat"
+
+	rookOrchestratorBackend = "rook"
+)
+
+// BalancerStatus is the response of "ceph balancer status"
+type BalancerStatus struct {
+	Active         bool     `json:"active"`
+	Mode           string   `json:"mode"`
+	Plans          []string `json:"plans"`
+	OptimizeResult string   `json:"optimize_result"`
+	// only reported by nautilus and newer
+	NoOptimizationNeeded bool `json:"no_optimization_needed"`
+}
+
+// OrchestratorStatus is the response of "ceph orchestrator status"
+type OrchestratorStatus struct {
+	Backend   string
+	Available bool
+	// Message is the reason the backend is not available
+	Message string
+}
+
+// EnableBalancer turns on the mgr balancer module in the given mode (upmap or crush-compat)
+func (h *CephInstaller) EnableBalancer(namespace, mode string) error {
+	if mode != BalancerModeUpmap && mode != BalancerModeCrushCompat {
+		return fmt.Errorf("unsupported balancer mode %s", mode)
+	}
+
+	context := h.k8shelper.MakeContext()
+	if err := client.MgrEnableModule(context, namespace, "balancer", false); err != nil {
+		return err
+	}
+	if mode == BalancerModeUpmap {
+		// upmap entries are only understood by luminous or newer clients
+		if _, err := h.execCephCommand(namespace, "osd", "set-require-min-compat-client", "luminous", "--yes-i-really-mean-it"); err != nil {
+			return fmt.Errorf("failed to require luminous clients for upmap. %+v", err)
+		}
+	}
+	if _, err := h.execCephCommand(namespace, "balancer", "mode", mode); err != nil {
+		return fmt.Errorf("failed to set balancer mode %s. %+v", mode, err)
+	}
+	if _, err := h.execCephCommand(namespace, "balancer", "on"); err != nil {
+		return fmt.Errorf("failed to turn on the balancer. %+v", err)
+	}
+
+	logger.Infof("enabled the balancer in mode %s", mode)
+	return nil
+}
+
+// GetBalancerStatus returns the current status of the balancer
+func (h *CephInstaller) GetBalancerStatus(namespace string) (*BalancerStatus, error) {
+	output, err := h.execCephCommand(namespace, "balancer", "status")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get balancer status. %+v", err)
+	}
+	var status BalancerStatus
+	if err := json.Unmarshal(output, &status); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal balancer status: %s. %+v", string(output), err)
+	}
+	return &status, nil
+}
+
+// WaitForBalanced polls the balancer until it is active and has no pending optimizations. The last balancer
+// status is returned, also when giving up.
+func (h *CephInstaller) WaitForBalanced(namespace string) (*BalancerStatus, error) {
+	var status *BalancerStatus
+	var err error
+	for i := 0; i < utils.RetryLoop; i++ {
+		status, err = h.GetBalancerStatus(namespace)
+		if err == nil && status.isBalanced() {
+			logger.Infof("the cluster is balanced. %+v", *status)
+			return status, nil
+		}
+		logger.Infof("waiting for the cluster to be balanced. status=%+v, err=%v", status, err)
+		time.Sleep(utils.RetryInterval * time.Second)
+	}
+	if err != nil {
+		return status, err
+	}
+	return status, fmt.Errorf("gave up waiting for the cluster to be balanced. status: %+v", *status)
+}
+
+func (s *BalancerStatus) isBalanced() bool {
+	if !s.Active || len(s.Plans) > 0 {
+		return false
+	}
+	if s.NoOptimizationNeeded {
+		return true
+	}
+	// older releases don't report the result of the last optimization
+	return s.OptimizeResult == "" || strings.Contains(s.OptimizeResult, "Unable to find further optimization")
+}
+
+// VerifyOrchestratorBackend confirms with "ceph orchestrator status" that the operator set rook as the orchestrator
+// backend and that the backend is available. The operator only configures the orchestrator on nautilus and newer. The
+// status output is returned in the error on failure.
+func (h *CephInstaller) VerifyOrchestratorBackend(namespace string) error {
+	var output string
+	var err error
+	for i := 0; i < utils.RetryLoop; i++ {
+		// the nautilus orchestrator cli prints the status as text and ignores the json format
+		output, err = h.k8shelper.Exec(namespace, "rook-ceph-tools", "ceph", []string{"orchestrator", "status"})
+		if err == nil {
+			status := parseOrchestratorStatus(output)
+			if status.active() {
+				logger.Infof("the rook orchestrator backend is active")
+				return nil
+			}
+			err = fmt.Errorf("backend %q available=%t %s", status.Backend, status.Available, status.Message)
+		}
+		logger.Infof("waiting for the rook orchestrator backend. %v", err)
+		time.Sleep(utils.RetryInterval * time.Second)
+	}
+	return fmt.Errorf("the rook orchestrator backend is not active. orchestrator status: %s. %v", output, err)
+}
+
+// parseOrchestratorStatus parses the "Backend: rook" and "Available: True" lines of "ceph orchestrator status". The
+// reason is appended to the availability in parentheses if the backend is not available.
+func parseOrchestratorStatus(output string) *OrchestratorStatus {
+	status := &OrchestratorStatus{}
+	for _, line := range strings.Split(output, "\n") {
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		value := strings.TrimSpace(parts[1])
+		switch strings.TrimSpace(parts[0]) {
+		case "Backend":
+			status.Backend = value
+		case "Available":
+			status.Available = strings.HasPrefix(value, "True")
+			if i := strings.Index(value, "("); i >= 0 {
+				status.Message = strings.TrimSuffix(value[i+1:], ")")
+			}
+		}
+	}
+	return status
+}
+
+func (s *OrchestratorStatus) active() bool {
+	return s.Available && s.Backend == rookOrchestratorBackend
+}
+
+// MgrModuleList is the response of "ceph mgr module ls"
+type MgrModuleList struct {
+	EnabledModules []string `json:"enabled_modules"`
+	// only reported by nautilus and newer
+	AlwaysOnModules []string `json:"always_on_modules"`
+}
+
+// GetEnabledMgrModules returns the enabled mgr modules, including the modules that are always on
+func (h *CephInstaller) GetEnabledMgrModules(namespace string) ([]string, error) {
+	output, err := h.execCephCommand(namespace, "mgr", "module", "ls")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the mgr modules. %+v", err)
+	}
+	var list MgrModuleList
+	if err := json.Unmarshal(output, &list); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal mgr module ls: %s. %+v", string(output), err)
+	}
+	return append(list.EnabledModules, list.AlwaysOnModules...), nil
+}
+
+// VerifyMgrModulesEnabled waits for each of the mgr modules to be enabled, such as the prometheus module that the
+// operator enables in every cluster
+func (h *CephInstaller) VerifyMgrModulesEnabled(namespace string, modules []string) error {
+	if len(modules) == 0 {
+		return nil
+	}
+	var missing []string
+	for i := 0; i < utils.RetryLoop; i++ {
+		enabled, err := h.GetEnabledMgrModules(namespace)
+		if err == nil {
+			missing = missingModules(modules, enabled)
+			if len(missing) == 0 {
+				logger.Infof("mgr modules %v are enabled", modules)
+				return nil
+			}
+		}
+		logger.Infof("waiting for the mgr modules to be enabled. missing=%v, err=%v", missing, err)
+		time.Sleep(utils.RetryInterval * time.Second)
+	}
+	return fmt.Errorf("mgr modules %v are not enabled in cluster %s", missing, namespace)
+}
+
+func missingModules(expected, enabled []string) []string {
+	isEnabled := map[string]bool{}
+	for _, module := range enabled {
+		isEnabled[module] = true
+	}
+	var missing []string
+	for _, module := range expected {
+		if !isEnabled[module] {
+			missing = append(missing, module)
+		}
+	}
+	return missing
+}
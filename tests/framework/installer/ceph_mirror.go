@@ -0,0 +1,218 @@
+/*
+Copyright 2019 The Rook Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package installer
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/rook/rook/tests/framework/utils"
+)
+
+const (
+	// how long the journal of a mirrored image may take to be replayed in the secondary cluster
+	mirrorLagTimeout      = 10 * time.Minute
+	mirrorLagPollInterval = time.Second
+	// the size of the image and of the data written to measure the lag
+	mirrorLagImageSizeMB = "64"
+	mirrorLagWriteTotal  = "16M"
+)
+
+// matches the entries of the journal of the primary image that the secondary image did not replay yet
+var entriesBehindMasterRegex = regexp.MustCompile(`entries_behind_master=(\d+)`)
+
+// PromoteMirroredImage promotes the mirrored image to primary in the cluster and waits for the image to report
+// that it is primary. The rbd-mirror logs are collected on failure.
+func (h *CephInstaller) PromoteMirroredImage(namespace, poolName, imageName string) error {
+	return h.setMirroredImagePrimary(namespace, poolName, imageName, true)
+}
+
+// DemoteMirroredImage demotes the mirrored image to non-primary in the cluster and waits for the image to report
+// that it is no longer primary. The rbd-mirror logs are collected on failure.
+func (h *CephInstaller) DemoteMirroredImage(namespace, poolName, imageName string) error {
+	return h.setMirroredImagePrimary(namespace, poolName, imageName, false)
+}
+
+func (h *CephInstaller) setMirroredImagePrimary(namespace, poolName, imageName string, primary bool) error {
+	err := h.changeMirroredImagePrimary(namespace, poolName, imageName, primary)
+	if err != nil {
+		h.k8shelper.GetRookLogs("rook-ceph-rbd-mirror", Env.HostType, namespace, "mirror-"+imageName)
+	}
+	return err
+}
+
+func (h *CephInstaller) changeMirroredImagePrimary(namespace, poolName, imageName string, primary bool) error {
+	image := poolName + "/" + imageName
+	action := "demote"
+	if primary {
+		action = "promote"
+	}
+	if _, err := h.execRBDCommand(namespace, "mirror", "image", action, image); err != nil {
+		return fmt.Errorf("failed to %s image %s. %+v", action, image, err)
+	}
+
+	var err error
+	for i := 0; i < utils.RetryLoop; i++ {
+		var isPrimary bool
+		isPrimary, err = h.isMirroredImagePrimary(namespace, image)
+		if err == nil && isPrimary == primary {
+			logger.Infof("image %s is %sd. %s", image, action, h.mirroredImageStatus(namespace, image))
+			return nil
+		}
+		if err == nil {
+			err = fmt.Errorf("image primary=%t", isPrimary)
+		}
+		logger.Infof("waiting for image %s to be %sd. %v", image, action, err)
+		time.Sleep(utils.RetryInterval * time.Second)
+	}
+	return fmt.Errorf("gave up waiting for image %s to be %sd. %+v. status: %s", image, action, err, h.mirroredImageStatus(namespace, image))
+}
+
+// isMirroredImagePrimary returns whether the image is the primary copy according to "rbd info"
+func (h *CephInstaller) isMirroredImagePrimary(namespace, image string) (bool, error) {
+	output, err := h.execRBDCommand(namespace, "info", image)
+	if err != nil {
+		return false, fmt.Errorf("failed to get info of image %s. %+v", image, err)
+	}
+	var info struct {
+		Mirroring *struct {
+			State   string `json:"state"`
+			Primary bool   `json:"primary"`
+		} `json:"mirroring"`
+	}
+	if err := json.Unmarshal(output, &info); err != nil {
+		return false, fmt.Errorf("failed to unmarshal info of image %s: %s. %+v", image, string(output), err)
+	}
+	if info.Mirroring == nil || info.Mirroring.State != "enabled" {
+		return false, fmt.Errorf("mirroring is not enabled on image %s", image)
+	}
+	return info.Mirroring.Primary, nil
+}
+
+// mirroredImageStatus returns the output of "rbd mirror image status" for logging
+func (h *CephInstaller) mirroredImageStatus(namespace, image string) string {
+	output, err := h.execRBDCommand(namespace, "mirror", "image", "status", image)
+	if err != nil {
+		return fmt.Sprintf("unknown (%v)", err)
+	}
+	return string(output)
+}
+
+// MeasureMirrorLag creates a journaled image in the mirrored pool of the primary cluster, waits for the image to be
+// replicated to the secondary cluster, then writes to the image and measures how long the secondary takes to replay
+// all the written entries. The image is removed at the end.
+func (h *CephInstaller) MeasureMirrorLag(primaryNS, secondaryNS, poolName string) (time.Duration, error) {
+	imageName := fmt.Sprintf("mirror-lag-%d", time.Now().Unix())
+	image := poolName + "/" + imageName
+	if _, err := h.execRBDCommand(primaryNS, "create", image, "--size", mirrorLagImageSizeMB,
+		"--image-feature", "layering,exclusive-lock,journaling"); err != nil {
+		return 0, fmt.Errorf("failed to create image %s. %+v", image, err)
+	}
+	defer func() {
+		if _, err := h.execRBDCommand(primaryNS, "rm", image); err != nil {
+			logger.Warningf("failed to remove image %s. %+v", image, err)
+		}
+	}()
+	if err := h.enableImageMirroring(primaryNS, poolName, image); err != nil {
+		return 0, err
+	}
+
+	// the initial sync of the image is not part of the lag
+	if _, err := h.waitForMirrorReplayed(secondaryNS, image, mirrorLagTimeout); err != nil {
+		return 0, fmt.Errorf("image %s was not replicated to cluster %s. %+v", image, secondaryNS, err)
+	}
+
+	if _, err := h.execRBDCommand(primaryNS, "bench", image, "--io-type", "write", "--io-size", "4K", "--io-total", mirrorLagWriteTotal); err != nil {
+		return 0, fmt.Errorf("failed to write to image %s. %+v", image, err)
+	}
+	lag, err := h.waitForMirrorReplayed(secondaryNS, image, mirrorLagTimeout)
+	if err != nil {
+		h.k8shelper.GetRookLogs("rook-ceph-rbd-mirror", Env.HostType, secondaryNS, "mirror-lag-"+imageName)
+		return 0, fmt.Errorf("image %s was not replayed in cluster %s. %+v", image, secondaryNS, err)
+	}
+	logger.Infof("image %s was replayed in cluster %s %v after the write", image, secondaryNS, lag)
+	return lag, nil
+}
+
+// enableImageMirroring enables mirroring of the image unless the pool mirrors all its images
+func (h *CephInstaller) enableImageMirroring(namespace, poolName, image string) error {
+	output, err := h.execRBDCommand(namespace, "mirror", "pool", "info", poolName)
+	if err != nil {
+		return fmt.Errorf("failed to get the mirroring info of pool %s. %+v", poolName, err)
+	}
+	var info struct {
+		Mode string `json:"mode"`
+	}
+	if err := json.Unmarshal(output, &info); err != nil {
+		return fmt.Errorf("failed to unmarshal the mirroring info of pool %s: %s. %+v", poolName, string(output), err)
+	}
+	switch info.Mode {
+	case "pool":
+		return nil
+	case "image":
+		if _, err := h.execRBDCommand(namespace, "mirror", "image", "enable", image); err != nil {
+			return fmt.Errorf("failed to enable mirroring of image %s. %+v", image, err)
+		}
+		return nil
+	}
+	return fmt.Errorf("mirroring is not enabled on pool %s", poolName)
+}
+
+// waitForMirrorReplayed waits until the mirrored image in the secondary cluster has replayed all the entries of the
+// primary journal and returns the time it waited
+func (h *CephInstaller) waitForMirrorReplayed(namespace, image string, timeout time.Duration) (time.Duration, error) {
+	start := time.Now()
+	var err error
+	for time.Since(start) < timeout {
+		var output []byte
+		output, err = h.execRBDCommand(namespace, "mirror", "image", "status", image)
+		if err == nil {
+			var behind int
+			if behind, err = entriesBehindMaster(output); err == nil && behind == 0 {
+				return time.Since(start), nil
+			}
+			if err == nil {
+				err = fmt.Errorf("%d entries behind the primary", behind)
+			}
+		}
+		time.Sleep(mirrorLagPollInterval)
+	}
+	return 0, fmt.Errorf("gave up after %v. %+v", timeout, err)
+}
+
+// entriesBehindMaster returns the number of journal entries the secondary image is behind from the json output of
+// "rbd mirror image status". An error is returned if the image is not replaying.
+func entriesBehindMaster(statusJSON []byte) (int, error) {
+	var status struct {
+		State       string `json:"state"`
+		Description string `json:"description"`
+	}
+	if err := json.Unmarshal(statusJSON, &status); err != nil {
+		return 0, fmt.Errorf("failed to unmarshal the mirror image status: %s. %+v", string(statusJSON), err)
+	}
+	if status.State != "up+replaying" {
+		return 0, fmt.Errorf("image is %s: %s", status.State, status.Description)
+	}
+	match := entriesBehindMasterRegex.FindStringSubmatch(status.Description)
+	if match == nil {
+		return 0, fmt.Errorf("no replay position in the status %q", status.Description)
+	}
+	return strconv.Atoi(match[1])
+}
@@ -0,0 +1,155 @@
+/*
+Copyright 2019 The Rook Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package installer
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	monLabel              = "app=rook-ceph-mon"
+	monEndpointsConfigMap = "rook-ceph-mon-endpoints"
+)
+
+// VerifyMonAntiAffinity confirms that each mon pod runs on a different node when the cluster does not allow multiple
+// mons per node. The distribution of the mons across the nodes is returned in the error.
+func (h *CephInstaller) VerifyMonAntiAffinity(namespace string) error {
+	cluster, err := h.k8shelper.RookClientset.CephV1().CephClusters(namespace).Get(namespace, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to get the cluster in namespace %s. %+v", namespace, err)
+	}
+	if cluster.Spec.Mon.AllowMultiplePerNode {
+		logger.Infof("cluster in namespace %s allows multiple mons per node, not verifying the mon placement", namespace)
+		return nil
+	}
+
+	pods, err := h.k8shelper.Clientset.CoreV1().Pods(namespace).List(metav1.ListOptions{LabelSelector: monLabel})
+	if err != nil {
+		return fmt.Errorf("failed to list the mon pods. %+v", err)
+	}
+	distribution := monNodeDistribution(pods.Items)
+	for _, mons := range distribution {
+		if len(mons) > 1 {
+			return fmt.Errorf("multiple mons share a node: %s", formatMonDistribution(distribution))
+		}
+	}
+	logger.Infof("all %d mons run on different nodes: %s", len(pods.Items), formatMonDistribution(distribution))
+	return nil
+}
+
+// monNodeDistribution returns the names of the mon pods keyed by the node they run on
+func monNodeDistribution(pods []v1.Pod) map[string][]string {
+	distribution := map[string][]string{}
+	for _, pod := range pods {
+		distribution[pod.Spec.NodeName] = append(distribution[pod.Spec.NodeName], pod.Name)
+	}
+	return distribution
+}
+
+func formatMonDistribution(distribution map[string][]string) string {
+	nodes := make([]string, 0, len(distribution))
+	for node := range distribution {
+		nodes = append(nodes, node)
+	}
+	sort.Strings(nodes)
+	parts := make([]string, len(nodes))
+	for i, node := range nodes {
+		mons := append([]string{}, distribution[node]...)
+		sort.Strings(mons)
+		parts[i] = fmt.Sprintf("%s=[%s]", node, strings.Join(mons, " "))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// VerifyNoDuplicateMons confirms that the mon deployments and the mon endpoints configmap describe the same mons,
+// without duplicate mon names or endpoints. The mon inventory is returned in the error on inconsistencies.
+func (h *CephInstaller) VerifyNoDuplicateMons(namespace string) error {
+	deployments, err := h.k8shelper.Clientset.ExtensionsV1beta1().Deployments(namespace).List(metav1.ListOptions{LabelSelector: monLabel})
+	if err != nil {
+		return fmt.Errorf("failed to list the mon deployments. %+v", err)
+	}
+	var deploymentMons []string
+	for _, d := range deployments.Items {
+		deploymentMons = append(deploymentMons, d.Labels["mon"])
+	}
+	cm, err := h.k8shelper.Clientset.CoreV1().ConfigMaps(namespace).Get(monEndpointsConfigMap, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to get the mon endpoints. %+v", err)
+	}
+
+	endpoints := cm.Data["data"]
+	if issues := monInventoryIssues(deploymentMons, endpoints); len(issues) > 0 {
+		sort.Strings(deploymentMons)
+		return fmt.Errorf("inconsistent mons: %s. deployments: %v, endpoints: %s", strings.Join(issues, "; "), deploymentMons, endpoints)
+	}
+	logger.Infof("mon deployments %v match the mon endpoints %s", deploymentMons, endpoints)
+	return nil
+}
+
+// monInventoryIssues compares the mons of the deployments with the mons in the endpoints in the "a=10.0.0.1:6789,..."
+// format of the mon endpoints configmap
+func monInventoryIssues(deploymentMons []string, endpoints string) []string {
+	var issues []string
+	deployed := map[string]int{}
+	for _, mon := range deploymentMons {
+		deployed[mon]++
+	}
+	listed := map[string]int{}
+	addresses := map[string][]string{}
+	for _, entry := range strings.Split(endpoints, ",") {
+		if entry == "" {
+			continue
+		}
+		parts := strings.Split(entry, "=")
+		if len(parts) != 2 {
+			issues = append(issues, fmt.Sprintf("invalid endpoint %q", entry))
+			continue
+		}
+		listed[parts[0]]++
+		addresses[parts[1]] = append(addresses[parts[1]], parts[0])
+	}
+
+	for mon, count := range deployed {
+		if count > 1 {
+			issues = append(issues, fmt.Sprintf("mon %s has %d deployments", mon, count))
+		}
+		if listed[mon] == 0 {
+			issues = append(issues, fmt.Sprintf("mon %s has a deployment but no endpoint", mon))
+		}
+	}
+	for mon, count := range listed {
+		if count > 1 {
+			issues = append(issues, fmt.Sprintf("mon %s is listed %d times in the endpoints", mon, count))
+		}
+		if deployed[mon] == 0 {
+			issues = append(issues, fmt.Sprintf("mon %s has an endpoint but no deployment", mon))
+		}
+	}
+	for address, mons := range addresses {
+		if len(mons) > 1 {
+			sort.Strings(mons)
+			issues = append(issues, fmt.Sprintf("mons %v share the endpoint %s", mons, address))
+		}
+	}
+	sort.Strings(issues)
+	return issues
+}
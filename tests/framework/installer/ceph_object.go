@@ -0,0 +1,491 @@
+/*
+Copyright 2019 The Rook Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package installer
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rook/rook/pkg/daemon/ceph/client"
+	"github.com/rook/rook/pkg/daemon/ceph/rgw"
+	"github.com/rook/rook/tests/framework/utils"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// rgwHealthPath answers 200 to anonymous requests once rgw serves requests
+	rgwHealthPath         = "/"
+	rgwReadinessTimeout   = 5 * time.Minute
+	rgwHealthCheckTimeout = 10 * time.Second
+	// the s3 error code of the writes rejected by a quota
+	quotaExceededCode = "QuotaExceeded"
+)
+
+var (
+	// the pools created by the operator for each object store, named <store>.<pool>
+	objectMetadataPools = []string{"rgw.control", "rgw.meta", "rgw.log", "rgw.buckets.index"}
+	objectDataPools     = []string{"rgw.buckets.data"}
+)
+
+// VerifyObjectStorePools confirms the rgw pools of the object store were created in ceph with the requested
+// replication or erasure coding and device class. All the mismatched pools are reported in the error.
+func (h *CephInstaller) VerifyObjectStorePools(namespace, storeName string, metadataPool, dataPool ObjectPoolSpec) error {
+	var failures []string
+	for _, pool := range objectMetadataPools {
+		if err := h.verifyObjectPool(namespace, storeName+"."+pool, metadataPool); err != nil {
+			failures = append(failures, err.Error())
+		}
+	}
+	for _, pool := range objectDataPools {
+		if err := h.verifyObjectPool(namespace, storeName+"."+pool, dataPool); err != nil {
+			failures = append(failures, err.Error())
+		}
+	}
+	if len(failures) > 0 {
+		return fmt.Errorf("object store %s has unexpected pools: %s", storeName, strings.Join(failures, "; "))
+	}
+
+	logger.Infof("the pools of object store %s match the requested config", storeName)
+	return nil
+}
+
+func (h *CephInstaller) verifyObjectPool(namespace, poolName string, expected ObjectPoolSpec) error {
+	context := h.k8shelper.MakeContext()
+	details, err := client.GetPoolDetails(context, namespace, poolName)
+	if err != nil {
+		return fmt.Errorf("pool %s not found. %+v", poolName, err)
+	}
+	if expected.DeviceClass != "" {
+		if err := h.verifyPoolDeviceClass(namespace, poolName, expected.DeviceClass); err != nil {
+			return err
+		}
+	}
+
+	if !expected.erasureCoded() {
+		if details.ErasureCodeProfile != "" {
+			return fmt.Errorf("pool %s is erasure coded instead of replicated", poolName)
+		}
+		if int(details.Size) != expected.Replicas {
+			return fmt.Errorf("pool %s has size %d instead of %d", poolName, details.Size, expected.Replicas)
+		}
+		return nil
+	}
+
+	if details.ErasureCodeProfile == "" {
+		return fmt.Errorf("pool %s is not erasure coded", poolName)
+	}
+	profile, err := client.GetErasureCodeProfileDetails(context, namespace, details.ErasureCodeProfile)
+	if err != nil {
+		return fmt.Errorf("failed to get the erasure code profile of pool %s. %+v", poolName, err)
+	}
+	if int(profile.DataChunkCount) != expected.DataChunks || int(profile.CodingChunkCount) != expected.CodingChunks {
+		return fmt.Errorf("pool %s has k=%d,m=%d instead of k=%d,m=%d", poolName,
+			profile.DataChunkCount, profile.CodingChunkCount, expected.DataChunks, expected.CodingChunks)
+	}
+	return nil
+}
+
+// verifyPoolDeviceClass confirms the crush rule of the pool only takes the osds of the device class
+func (h *CephInstaller) verifyPoolDeviceClass(namespace, poolName, deviceClass string) error {
+	output, err := h.execCephCommand(namespace, "osd", "pool", "get", poolName, "crush_rule")
+	if err != nil {
+		return fmt.Errorf("failed to get the crush rule of pool %s. %+v", poolName, err)
+	}
+	var pool struct {
+		CrushRule string `json:"crush_rule"`
+	}
+	if err := json.Unmarshal(output, &pool); err != nil {
+		return fmt.Errorf("failed to unmarshal the crush rule of pool %s: %s. %+v", poolName, string(output), err)
+	}
+	rule, err := h.execCephCommand(namespace, "osd", "crush", "rule", "dump", pool.CrushRule)
+	if err != nil {
+		return fmt.Errorf("failed to dump crush rule %s. %+v", pool.CrushRule, err)
+	}
+	classes, err := crushRuleDeviceClasses(rule)
+	if err != nil {
+		return err
+	}
+	if len(classes) != 1 || classes[0] != deviceClass {
+		return fmt.Errorf("crush rule %s of pool %s takes device classes %v instead of %s", pool.CrushRule, poolName, classes, deviceClass)
+	}
+	return nil
+}
+
+// crushRuleDeviceClasses returns the device classes of the take steps of the crush rule. The shadow buckets of a
+// device class are named <bucket>~<class>. A take step without a device class is reported as an empty class.
+func crushRuleDeviceClasses(ruleJSON []byte) ([]string, error) {
+	var rule struct {
+		Name  string `json:"rule_name"`
+		Steps []struct {
+			Op       string `json:"op"`
+			ItemName string `json:"item_name"`
+		} `json:"steps"`
+	}
+	if err := json.Unmarshal(ruleJSON, &rule); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal crush rule: %s. %+v", string(ruleJSON), err)
+	}
+	var classes []string
+	for _, step := range rule.Steps {
+		if step.Op != "take" {
+			continue
+		}
+		class := ""
+		if i := strings.Index(step.ItemName, "~"); i >= 0 {
+			class = step.ItemName[i+1:]
+		}
+		classes = append(classes, class)
+	}
+	return classes, nil
+}
+
+// ValidateObjectStorage runs an s3 smoke test against the rgw of the object store. A user and a bucket are created,
+// an object is written and read back, then the object, bucket and user are removed. The rgw logs are collected on
+// failure.
+func (h *CephInstaller) ValidateObjectStorage(namespace, storeName string) error {
+	err := h.validateObjectStorage(namespace, storeName, newPathStyleS3Helper)
+	if err != nil {
+		h.k8shelper.GetRookLogs("rook-ceph-rgw", Env.HostType, namespace, "object-validation-"+storeName)
+	}
+	return err
+}
+
+// ValidateVirtualHostedObjectStorage runs the s3 smoke test with virtual-hosted-style requests. The buckets are
+// addressed as <bucket>.<dnsName>, which requires the object store to be created with the dns name. The rgw logs are
+// collected on failure.
+func (h *CephInstaller) ValidateVirtualHostedObjectStorage(namespace, storeName, dnsName string) error {
+	err := h.validateObjectStorage(namespace, storeName, func(endpoint, keyID, keySecret string) (*utils.S3Helper, error) {
+		return utils.CreateNewVirtualHostedS3Helper(endpoint, dnsName, keyID, keySecret)
+	})
+	if err != nil {
+		h.k8shelper.GetRookLogs("rook-ceph-rgw", Env.HostType, namespace, "object-virtual-hosted-"+storeName)
+	}
+	return err
+}
+
+func newPathStyleS3Helper(endpoint, keyID, keySecret string) (*utils.S3Helper, error) {
+	return utils.CreateNewS3Helper(endpoint, keyID, keySecret), nil
+}
+
+func (h *CephInstaller) validateObjectStorage(namespace, storeName string, newS3Helper func(endpoint, keyID, keySecret string) (*utils.S3Helper, error)) error {
+	context := rgw.NewContext(h.k8shelper.MakeContext(), storeName, namespace)
+	userID := "validation-" + storeName
+	displayName := "object storage validation"
+	user, _, err := rgw.CreateUser(context, rgw.ObjectUser{UserID: userID, DisplayName: &displayName})
+	if err != nil {
+		return fmt.Errorf("failed to create user %s. %+v", userID, err)
+	}
+	defer func() {
+		if _, _, err := rgw.DeleteUser(context, userID); err != nil {
+			logger.Warningf("failed to delete user %s. %+v", userID, err)
+		}
+	}()
+
+	endpoint, err := h.getS3Endpoint(namespace, storeName)
+	if err != nil {
+		return err
+	}
+	s3, err := newS3Helper(endpoint, *user.AccessKey, *user.SecretKey)
+	if err != nil {
+		return fmt.Errorf("failed to create the s3 client for %s. %+v", endpoint, err)
+	}
+
+	bucket := "validation-bucket"
+	if _, err := s3.CreateBucket(bucket); err != nil {
+		return fmt.Errorf("failed to create bucket %s. %+v", bucket, err)
+	}
+	defer s3.DeleteBucket(bucket)
+
+	key := "validation-object"
+	body := fmt.Sprintf("rook object storage validation %d", time.Now().UnixNano())
+	if _, err := s3.PutObjectInBucket(bucket, body, key, "text/plain"); err != nil {
+		return fmt.Errorf("failed to put object %s. %+v", key, err)
+	}
+	defer s3.DeleteObjectInBucket(bucket, key)
+
+	read, err := s3.GetObjectInBucket(bucket, key)
+	if err != nil {
+		return fmt.Errorf("failed to get object %s. %+v", key, err)
+	}
+	if read != body {
+		return fmt.Errorf("object %s read back as %q instead of %q", key, read, body)
+	}
+
+	logger.Infof("validated s3 put/get on object store %s at %s", storeName, endpoint)
+	return nil
+}
+
+// getS3Endpoint returns the endpoint of the rgw that can be reached from the tests, creating the external rgw
+// service when the tests are not running inside the cluster
+func (h *CephInstaller) getS3Endpoint(namespace, storeName string) (string, error) {
+	if !h.k8shelper.RunningInCluster {
+		if err := h.k8shelper.CreateExternalRGWService(namespace, storeName); err != nil && !strings.Contains(err.Error(), "AlreadyExists") {
+			return "", err
+		}
+	}
+	endpoint, err := h.k8shelper.GetRGWServiceURL(storeName, namespace)
+	if err != nil {
+		return "", fmt.Errorf("failed to get the rgw endpoint. %+v", err)
+	}
+	return endpoint, nil
+}
+
+// VerifyRGWReadiness polls the health endpoint of the rgw through the service of the object store until it answers
+// 200, so the object tests do not race the start of the rgw. The rgw logs are collected on failure.
+func (h *CephInstaller) VerifyRGWReadiness(namespace, storeName string) error {
+	endpoint, err := h.getS3Endpoint(namespace, storeName)
+	if err != nil {
+		return err
+	}
+	url := "http://" + endpoint + rgwHealthPath
+	start := time.Now()
+	for {
+		err = checkHTTPOK(url)
+		if err == nil {
+			logger.Infof("rgw of object store %s is ready at %s after %v", storeName, endpoint, time.Since(start))
+			return nil
+		}
+		if time.Since(start) > rgwReadinessTimeout {
+			break
+		}
+		logger.Infof("waiting for rgw of object store %s to be ready. %v", storeName, err)
+		time.Sleep(utils.RetryInterval * time.Second)
+	}
+	h.k8shelper.GetRookLogs("rook-ceph-rgw", Env.HostType, namespace, "rgw-readiness-"+storeName)
+	return fmt.Errorf("rgw of object store %s was not ready within %v. %+v", storeName, rgwReadinessTimeout, err)
+}
+
+// checkHTTPOK returns an error if the url does not answer with a 200
+func checkHTTPOK(url string) error {
+	client := &http.Client{Timeout: rgwHealthCheckTimeout}
+	resp, err := client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s answered %s", url, resp.Status)
+	}
+	return nil
+}
+
+type stressUser struct {
+	name    string
+	s3      *utils.S3Helper
+	buckets []string
+}
+
+// StressObjectStore creates the users with CephObjectStoreUser CRs and the buckets of each user with s3, and
+// verifies that all of them are provisioned. The time to provision the users and the buckets is logged, and all the
+// failures are reported in the error. The buckets and users are removed at the end.
+func (h *CephInstaller) StressObjectStore(namespace, storeName string, numUsers, numBucketsPerUser int) error {
+	if numUsers <= 0 || numBucketsPerUser <= 0 {
+		return fmt.Errorf("the number of users and buckets per user must be positive, got %d users and %d buckets per user", numUsers, numBucketsPerUser)
+	}
+	endpoint, err := h.getS3Endpoint(namespace, storeName)
+	if err != nil {
+		return err
+	}
+
+	users := make([]*stressUser, numUsers)
+	manifests := make([]string, numUsers)
+	for i := range users {
+		users[i] = &stressUser{name: fmt.Sprintf("stress-user-%d", i)}
+		manifests[i] = h.Manifests.GetObjectStoreUser(namespace, users[i].name, users[i].name, storeName)
+	}
+	defer h.cleanupStressUsers(namespace, users)
+
+	start := time.Now()
+	if _, err := h.k8shelper.ResourceOperation("apply", strings.Join(manifests, "\n---\n")); err != nil {
+		return fmt.Errorf("failed to create the object store users. %+v", err)
+	}
+	var failures []string
+	for _, user := range users {
+		accessKey, secretKey, err := h.waitForObjectUserKeys(namespace, storeName, user.name)
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("user %s: %+v", user.name, err))
+			continue
+		}
+		user.s3 = utils.CreateNewS3Helper(endpoint, accessKey, secretKey)
+	}
+	logger.Infof("provisioned %d of %d users in %v", numUsers-len(failures), numUsers, time.Since(start))
+
+	start = time.Now()
+	buckets := 0
+	for _, user := range users {
+		if user.s3 == nil {
+			continue
+		}
+		for i := 0; i < numBucketsPerUser; i++ {
+			bucket := fmt.Sprintf("%s-bucket-%d", user.name, i)
+			if _, err := user.s3.CreateBucket(bucket); err != nil {
+				failures = append(failures, fmt.Sprintf("bucket %s: %+v", bucket, err))
+				continue
+			}
+			user.buckets = append(user.buckets, bucket)
+			buckets++
+		}
+	}
+	logger.Infof("created %d of %d buckets in %v", buckets, numUsers*numBucketsPerUser, time.Since(start))
+
+	if len(failures) > 0 {
+		return fmt.Errorf("%d failures provisioning %d users with %d buckets each: %s",
+			len(failures), numUsers, numBucketsPerUser, strings.Join(failures, "; "))
+	}
+	return nil
+}
+
+// waitForObjectUserKeys waits for the operator to create the secret with the s3 keys of the user
+func (h *CephInstaller) waitForObjectUserKeys(namespace, storeName, userName string) (string, string, error) {
+	secretName := fmt.Sprintf("rook-ceph-object-user-%s-%s", storeName, userName)
+	var err error
+	for i := 0; i < utils.RetryLoop; i++ {
+		var secret *v1.Secret
+		secret, err = h.k8shelper.Clientset.CoreV1().Secrets(namespace).Get(secretName, metav1.GetOptions{})
+		if err == nil {
+			return string(secret.Data["AccessKey"]), string(secret.Data["SecretKey"]), nil
+		}
+		time.Sleep(utils.RetryInterval * time.Second)
+	}
+	return "", "", fmt.Errorf("gave up waiting for secret %s. %+v", secretName, err)
+}
+
+// rgwUserInfo is the subset of "radosgw-admin user info" with the quota of the user
+type rgwUserInfo struct {
+	UserQuota struct {
+		Enabled bool  `json:"enabled"`
+		MaxSize int64 `json:"max_size"`
+	} `json:"user_quota"`
+}
+
+// SetAndVerifyUserQuota creates the user with a CephObjectStoreUser CR, sets a quota of the max size on the user with
+// radosgw-admin and confirms that a write within the quota is accepted while a write beyond it is rejected. The
+// observed behavior is logged and reported in the error. The user and its data are removed at the end.
+func (h *CephInstaller) SetAndVerifyUserQuota(namespace, storeName, userName string, maxSizeBytes int64) error {
+	if maxSizeBytes < 2 {
+		return fmt.Errorf("invalid quota of %d bytes", maxSizeBytes)
+	}
+	if _, err := h.k8shelper.ResourceOperation("apply", h.Manifests.GetObjectStoreUser(namespace, userName, userName, storeName)); err != nil {
+		return fmt.Errorf("failed to create user %s. %+v", userName, err)
+	}
+	defer func() {
+		if _, err := h.k8shelper.DeleteResource("-n", namespace, "CephObjectStoreUser", userName); err != nil {
+			logger.Warningf("failed to delete user %s. %+v", userName, err)
+		}
+	}()
+	accessKey, secretKey, err := h.waitForObjectUserKeys(namespace, storeName, userName)
+	if err != nil {
+		return err
+	}
+
+	realm := []string{"--rgw-realm=" + storeName, "--rgw-zonegroup=" + storeName}
+	quotaArgs := append([]string{"--quota-scope=user", "--uid=" + userName}, realm...)
+	if _, err := h.execRadosgwAdmin(namespace, append([]string{"quota", "set", "--max-size=" + strconv.FormatInt(maxSizeBytes, 10)}, quotaArgs...)...); err != nil {
+		return err
+	}
+	if _, err := h.execRadosgwAdmin(namespace, append([]string{"quota", "enable"}, quotaArgs...)...); err != nil {
+		return err
+	}
+	output, err := h.execRadosgwAdmin(namespace, append([]string{"user", "info", "--uid=" + userName}, realm...)...)
+	if err != nil {
+		return err
+	}
+	if err := verifyUserQuota(output, maxSizeBytes); err != nil {
+		return fmt.Errorf("quota of user %s was not set. %+v", userName, err)
+	}
+
+	endpoint, err := h.getS3Endpoint(namespace, storeName)
+	if err != nil {
+		return err
+	}
+	err = verifyQuotaEnforced(utils.CreateNewS3Helper(endpoint, accessKey, secretKey), "quota-"+userName, maxSizeBytes)
+	if err != nil {
+		h.k8shelper.GetRookLogs("rook-ceph-rgw", Env.HostType, namespace, "user-quota-"+userName)
+		return fmt.Errorf("quota of %d bytes of user %s is not enforced. %+v", maxSizeBytes, userName, err)
+	}
+	return nil
+}
+
+// verifyQuotaEnforced writes an object of half the quota, which must be accepted, and an object larger than the
+// quota, which must be rejected. The large write is retried while the rgw may still have the user cached without
+// the quota.
+func verifyQuotaEnforced(s3 *utils.S3Helper, bucket string, maxSizeBytes int64) error {
+	if _, err := s3.CreateBucket(bucket); err != nil {
+		return fmt.Errorf("failed to create bucket %s. %+v", bucket, err)
+	}
+	defer s3.DeleteBucket(bucket)
+
+	if _, err := s3.PutObjectInBucket(bucket, strings.Repeat("a", int(maxSizeBytes/2)), "within-quota", "text/plain"); err != nil {
+		return fmt.Errorf("write of %d bytes within the quota was rejected. %+v", maxSizeBytes/2, err)
+	}
+	defer s3.DeleteObjectInBucket(bucket, "within-quota")
+
+	body := strings.Repeat("a", int(maxSizeBytes+1))
+	for i := 0; i < utils.RetryLoop; i++ {
+		_, err := s3.PutObjectInBucket(bucket, body, "beyond-quota", "text/plain")
+		if err != nil {
+			if !strings.Contains(err.Error(), quotaExceededCode) {
+				return fmt.Errorf("write of %d bytes beyond the quota failed without %s. %+v", len(body), quotaExceededCode, err)
+			}
+			logger.Infof("write of %d bytes within the quota was accepted and write of %d bytes beyond it was rejected with %s",
+				maxSizeBytes/2, len(body), quotaExceededCode)
+			return nil
+		}
+		s3.DeleteObjectInBucket(bucket, "beyond-quota")
+		logger.Infof("write of %d bytes beyond the quota was accepted, retrying", len(body))
+		time.Sleep(utils.RetryInterval * time.Second)
+	}
+	return fmt.Errorf("write of %d bytes beyond the quota was accepted", len(body))
+}
+
+// verifyUserQuota confirms the output of "radosgw-admin user info" has the user quota enabled with the max size
+func verifyUserQuota(output string, maxSizeBytes int64) error {
+	var info rgwUserInfo
+	if err := json.Unmarshal([]byte(output), &info); err != nil {
+		return fmt.Errorf("failed to unmarshal user info. %+v", err)
+	}
+	if !info.UserQuota.Enabled || info.UserQuota.MaxSize != maxSizeBytes {
+		return fmt.Errorf("user quota is %+v, expected a max size of %d bytes", info.UserQuota, maxSizeBytes)
+	}
+	return nil
+}
+
+func (h *CephInstaller) cleanupStressUsers(namespace string, users []*stressUser) {
+	for _, user := range users {
+		for _, bucket := range user.buckets {
+			if _, err := user.s3.DeleteBucket(bucket); err != nil {
+				logger.Warningf("failed to delete bucket %s. %+v", bucket, err)
+			}
+		}
+		if _, err := h.k8shelper.DeleteResource("-n", namespace, "CephObjectStoreUser", user.name); err != nil {
+			logger.Warningf("failed to delete user %s. %+v", user.name, err)
+		}
+	}
+}
+
+// execRadosgwAdmin runs radosgw-admin in the toolbox and returns the json output
+func (h *CephInstaller) execRadosgwAdmin(namespace string, args ...string) (string, error) {
+	output, err := h.k8shelper.Exec(namespace, "rook-ceph-tools", "radosgw-admin", args)
+	if err != nil {
+		return "", fmt.Errorf("failed to run radosgw-admin %s. %+v", strings.Join(args, " "), err)
+	}
+	return output, nil
+}
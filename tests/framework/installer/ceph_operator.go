@@ -0,0 +1,307 @@
+/*
+Copyright 2019 The Rook Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package installer
+
+import (
+	"fmt"
+	"time"
+
+	cephv1 "github.com/rook/rook/pkg/apis/ceph.rook.io/v1"
+	"github.com/rook/rook/pkg/daemon/ceph/client"
+	"github.com/rook/rook/tests/framework/utils"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	operatorAppName = "rook-ceph-operator"
+	// the pool created to confirm the operator reconciles after a restart
+	operatorRestartPool = "operator-restart-test"
+	// the network policy that cuts the operator off from the api server
+	operatorIsolationPolicy = "rook-ceph-operator-isolation"
+)
+
+// RestartOperator deletes the operator pod of the cluster and waits for the deployment to start a new one. The new
+// operator must reconcile a pool created after the restart, and the cluster must be healthy before and after the
+// restart. The logs of the old and the new operator pods are collected.
+func (h *CephInstaller) RestartOperator(namespace string) error {
+	systemNamespace := SystemNamespace(namespace)
+	oldPods, err := h.k8shelper.GetPodNamesForApp(operatorAppName, systemNamespace)
+	if err != nil {
+		return fmt.Errorf("failed to get the operator pod. %+v", err)
+	}
+	if err := h.verifyCephHealthy(namespace); err != nil {
+		return fmt.Errorf("cluster is not healthy before the operator restart. %+v", err)
+	}
+
+	h.k8shelper.GetRookLogs(operatorAppName, Env.HostType, systemNamespace, "operator-before-restart")
+	for _, pod := range oldPods {
+		logger.Infof("deleting operator pod %s", pod)
+		if _, err := h.k8shelper.DeletePod(systemNamespace, pod); err != nil {
+			return fmt.Errorf("failed to delete operator pod %s. %+v", pod, err)
+		}
+	}
+	defer h.k8shelper.GetRookLogs(operatorAppName, Env.HostType, systemNamespace, "operator-after-restart")
+
+	newPod, err := h.waitForNewOperatorPod(systemNamespace, oldPods)
+	if err != nil {
+		return err
+	}
+	logger.Infof("operator pod %s replaced %v", newPod, oldPods)
+
+	if err := h.verifyOperatorReconciles(namespace); err != nil {
+		return fmt.Errorf("operator did not resume reconciling after the restart. %+v", err)
+	}
+	if err := h.verifyCephHealthy(namespace); err != nil {
+		return fmt.Errorf("cluster is not healthy after the operator restart. %+v", err)
+	}
+	return nil
+}
+
+// waitForNewOperatorPod waits for an operator pod that is not one of the old pods to be running and ready
+func (h *CephInstaller) waitForNewOperatorPod(systemNamespace string, oldPods []string) (string, error) {
+	old := map[string]bool{}
+	for _, pod := range oldPods {
+		old[pod] = true
+	}
+
+	for i := 0; i < utils.RetryLoop; i++ {
+		pods, err := h.k8shelper.Clientset.CoreV1().Pods(systemNamespace).List(metav1.ListOptions{LabelSelector: "app=" + operatorAppName})
+		if err != nil {
+			return "", fmt.Errorf("failed to list the operator pods. %+v", err)
+		}
+		for _, pod := range pods.Items {
+			if !old[pod.Name] && pod.DeletionTimestamp == nil && podReady(pod) {
+				return pod.Name, nil
+			}
+		}
+		logger.Infof("waiting for a new operator pod in namespace %s", systemNamespace)
+		time.Sleep(utils.RetryInterval * time.Second)
+	}
+	return "", fmt.Errorf("gave up waiting for a new operator pod in namespace %s", systemNamespace)
+}
+
+func podReady(pod v1.Pod) bool {
+	if pod.Status.Phase != v1.PodRunning {
+		return false
+	}
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == v1.PodReady {
+			return condition.Status == v1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// verifyOperatorReconciles creates a pool CR and waits for the operator to create the pool in ceph
+func (h *CephInstaller) verifyOperatorReconciles(namespace string) error {
+	if err := h.CreateBlockPools(namespace, []PoolSpec{{Name: operatorRestartPool, Replicas: 1}}); err != nil {
+		return err
+	}
+	if _, err := h.k8shelper.DeleteResource("-n", namespace, "CephBlockPool", operatorRestartPool); err != nil {
+		logger.Warningf("failed to delete pool %s. %+v", operatorRestartPool, err)
+	}
+	return nil
+}
+
+// verifyCephHealthy confirms that all the mons are in quorum and ceph does not report a health error
+func (h *CephInstaller) verifyCephHealthy(namespace string) error {
+	status, err := client.Status(h.k8shelper.MakeContext(), namespace)
+	if err != nil {
+		return err
+	}
+	if status.Health.Status == client.CephHealthErr {
+		return fmt.Errorf("ceph health is %s: %+v", status.Health.Status, status.Health.Checks)
+	}
+	if len(status.Quorum) != len(status.MonMap.Mons) {
+		return fmt.Errorf("only %d of %d mons are in quorum", len(status.Quorum), len(status.MonMap.Mons))
+	}
+	return nil
+}
+
+// UpdateSpecWhileDaemonDown stops the deployments of the daemon with the label, applies the mutation to the cluster CR
+// and confirms the operator reconciles the change and starts the daemon again. The daemon is started by the test if
+// the operator does not recover it, so that the following tests find the cluster intact. The final ceph health is
+// logged and reported in the error if the cluster is not healthy after the update.
+func (h *CephInstaller) UpdateSpecWhileDaemonDown(namespace, daemonLabel string, mutate func(*cephv1.CephCluster)) error {
+	deployments, err := h.k8shelper.Clientset.ExtensionsV1beta1().Deployments(namespace).List(metav1.ListOptions{LabelSelector: daemonLabel})
+	if err != nil {
+		return fmt.Errorf("failed to list the deployments with label %s. %+v", daemonLabel, err)
+	}
+	if len(deployments.Items) == 0 {
+		return fmt.Errorf("no deployments found with label %s in namespace %s", daemonLabel, namespace)
+	}
+	var names []string
+	for _, d := range deployments.Items {
+		names = append(names, d.Name)
+	}
+
+	logger.Infof("stopping the daemons %v", names)
+	if err := h.scaleDeployments(namespace, names, 0); err != nil {
+		return err
+	}
+	if !h.k8shelper.WaitUntilPodWithLabelDeleted(daemonLabel, namespace) {
+		h.scaleDeployments(namespace, names, 1)
+		return fmt.Errorf("the pods with label %s were not stopped", daemonLabel)
+	}
+
+	cluster, err := h.k8shelper.RookClientset.CephV1().CephClusters(namespace).Get(namespace, metav1.GetOptions{})
+	if err != nil {
+		h.scaleDeployments(namespace, names, 1)
+		return fmt.Errorf("failed to get the cluster in namespace %s. %+v", namespace, err)
+	}
+	mutate(cluster)
+	if _, err := h.k8shelper.RookClientset.CephV1().CephClusters(namespace).Update(cluster); err != nil {
+		h.scaleDeployments(namespace, names, 1)
+		return fmt.Errorf("failed to update the cluster in namespace %s. %+v", namespace, err)
+	}
+
+	err = h.waitForClusterUpdated(namespace)
+	if err == nil {
+		err = h.k8shelper.WaitForLabeledPodsToRun(daemonLabel, namespace)
+	}
+	if err != nil {
+		h.GatherAllRookLogs(namespace, SystemNamespace(namespace), "update-while-down")
+		h.scaleDeployments(namespace, names, 1)
+		return fmt.Errorf("the operator did not recover the daemons %v after the update. %+v", names, err)
+	}
+
+	status, err := client.Status(h.k8shelper.MakeContext(), namespace)
+	if err != nil {
+		return fmt.Errorf("failed to get the ceph status. %+v", err)
+	}
+	logger.Infof("the operator applied the update and recovered the daemons %v. ceph health is %s", names, status.Health.Status)
+	return h.verifyCephHealthy(namespace)
+}
+
+func (h *CephInstaller) scaleDeployments(namespace string, names []string, replicas int) error {
+	for _, name := range names {
+		if _, err := h.k8shelper.Kubectl("-n", namespace, "scale", "deployment", name, fmt.Sprintf("--replicas=%d", replicas)); err != nil {
+			return fmt.Errorf("failed to scale deployment %s to %d. %+v", name, replicas, err)
+		}
+	}
+	return nil
+}
+
+// waitForClusterUpdated waits for the operator to finish the update of the cluster. The operator reports the cluster
+// as updating while the update is reconciled, and as created once the update is applied.
+func (h *CephInstaller) waitForClusterUpdated(namespace string) error {
+	updating := false
+	var status cephv1.ClusterStatus
+	start := time.Now()
+	for time.Since(start) < h.osdPrepareTimeout() {
+		cluster, err := h.k8shelper.RookClientset.CephV1().CephClusters(namespace).Get(namespace, metav1.GetOptions{})
+		if err != nil {
+			return fmt.Errorf("failed to get the cluster in namespace %s. %+v", namespace, err)
+		}
+		status = cluster.Status
+		updating = updating || status.State == cephv1.ClusterStateUpdating
+		if done, err := clusterUpdateDone(updating, status); done {
+			return err
+		}
+		time.Sleep(utils.RetryInterval * time.Second)
+	}
+	return fmt.Errorf("gave up waiting for the update of cluster %s. state=%q, message=%q", namespace, status.State, status.Message)
+}
+
+// clusterUpdateDone returns whether the operator finished updating the cluster, and the error if the update failed.
+// The update is done when the cluster is created again after it was seen updating.
+func clusterUpdateDone(sawUpdating bool, status cephv1.ClusterStatus) (bool, error) {
+	if status.State == cephv1.ClusterStateError {
+		return true, fmt.Errorf("the operator failed to update the cluster: %s", status.Message)
+	}
+	return sawUpdating && status.State == cephv1.ClusterStateCreated, nil
+}
+
+// SimulateAPIServerUnavailability cuts the operator off from the api server for the duration with a network policy
+// that denies all the egress of the operator pod, then confirms the operator reconnects and reconciles each cluster and
+// that the clusters are healthy. At least one cluster must exist to confirm the operator reconciles after the
+// interruption. The operator logs are collected before and after the interruption. The disruption
+// only runs when chaos is enabled for the tests, and requires a network plugin that enforces network policies.
+func (h *CephInstaller) SimulateAPIServerUnavailability(duration time.Duration) error {
+	if !Env.EnableChaos {
+		logger.Infof("skipping the api server interruption since chaos is not enabled")
+		return nil
+	}
+	pods, err := h.k8shelper.Clientset.CoreV1().Pods(metav1.NamespaceAll).List(metav1.ListOptions{LabelSelector: "app=" + operatorAppName})
+	if err != nil || len(pods.Items) == 0 {
+		return fmt.Errorf("operator pod not found. %v", err)
+	}
+	systemNamespace := pods.Items[0].Namespace
+	clusters, err := h.k8shelper.RookClientset.CephV1().CephClusters(metav1.NamespaceAll).List(metav1.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to list the clusters. %+v", err)
+	}
+	if len(clusters.Items) == 0 {
+		return fmt.Errorf("no clusters found to confirm the operator reconciles after the interruption")
+	}
+	for _, cluster := range clusters.Items {
+		if err := h.verifyCephHealthy(cluster.Namespace); err != nil {
+			return fmt.Errorf("cluster %s is not healthy before the interruption. %+v", cluster.Namespace, err)
+		}
+	}
+
+	h.k8shelper.GetRookLogs(operatorAppName, Env.HostType, systemNamespace, "operator-before-apiserver-interruption")
+	policy := renderOperatorIsolationPolicy(systemNamespace)
+	if _, err := h.k8shelper.KubectlWithStdin(policy, createFromStdinArgs...); err != nil {
+		return fmt.Errorf("failed to isolate the operator. %+v", err)
+	}
+	isolated := true
+	defer func() {
+		// the operator must not stay isolated if the test fails before the isolation is removed
+		if !isolated {
+			return
+		}
+		if _, err := h.k8shelper.KubectlWithStdin(policy, deleteFromStdinArgs...); err != nil {
+			logger.Warningf("failed to remove the isolation of the operator. %+v", err)
+		}
+	}()
+	logger.Infof("isolated the operator from the api server for %v", duration)
+	time.Sleep(duration)
+	if _, err := h.k8shelper.KubectlWithStdin(policy, deleteFromStdinArgs...); err != nil {
+		return fmt.Errorf("failed to remove the isolation of the operator. %+v", err)
+	}
+	isolated = false
+	defer h.k8shelper.GetRookLogs(operatorAppName, Env.HostType, systemNamespace, "operator-after-apiserver-interruption")
+
+	// a running operator pod does not prove the operator reconnected, so each cluster must reconcile a new pool
+	for _, cluster := range clusters.Items {
+		if err := h.verifyOperatorReconciles(cluster.Namespace); err != nil {
+			return fmt.Errorf("operator did not resume reconciling cluster %s after the interruption. %+v", cluster.Namespace, err)
+		}
+		if err := h.verifyCephHealthy(cluster.Namespace); err != nil {
+			return fmt.Errorf("cluster %s is not healthy after the interruption. %+v", cluster.Namespace, err)
+		}
+	}
+	return nil
+}
+
+// renderOperatorIsolationPolicy returns the network policy that denies all the egress of the operator pods
+func renderOperatorIsolationPolicy(systemNamespace string) string {
+	return `apiVersion: networking.k8s.io/v1
+kind: NetworkPolicy
+metadata:
+  name: ` + operatorIsolationPolicy + `
+  namespace: ` + systemNamespace + `
+spec:
+  podSelector:
+    matchLabels:
+      app: ` + operatorAppName + `
+  policyTypes:
+  - Egress
+`
+}
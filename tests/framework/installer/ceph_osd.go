@@ -0,0 +1,1497 @@
+/*
+Copyright 2019 The Rook Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package installer
+
+import (
+	cryptorand "crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	rookalpha "github.com/rook/rook/pkg/apis/rook.io/v1alpha2"
+	"github.com/rook/rook/pkg/daemon/ceph/client"
+	"github.com/rook/rook/tests/framework/utils"
+	batch "k8s.io/api/batch/v1"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/kubernetes/pkg/kubelet/apis"
+)
+
+const (
+	osdPrepareLabel = "app=rook-ceph-osd-prepare"
+	osdIDLabel      = "ceph-osd-id"
+	// the pool and object that must stay readable across the rotation of the osd keys
+	keyRotationPool   = "key-rotation-test"
+	keyRotationObject = "key-rotation-test"
+	// the size in bytes of the luks keys generated by ceph-volume
+	luksKeySize = 128
+	// how long to wait for the osd of a removed device to be purged
+	osdRemovalTimeout = 10 * time.Minute
+	// how much the weight per capacity of an osd may differ from the other osds, allowing for rounding of the weights
+	osdWeightTolerance = 0.1
+	// the share of the average pgs per osd that each new osd must hold for the data to be considered rebalanced
+	osdRebalanceMinShare = 0.5
+	// DefaultOSDPrepareTimeout is how long to wait for the osd prepare jobs if the installer does not set a timeout
+	DefaultOSDPrepareTimeout = 10 * time.Minute
+	// the filesystem created on a device that rook must not wipe
+	foreignFilesystem = "ext4"
+)
+
+var (
+	// matches the osd prepare log lines such as "skipping device sdb that is in use (not by rook). fs: ext4, ownPartitions: true"
+	skippedDeviceRegex = regexp.MustCompile(`skipping device (\S+) (.*)$`)
+	// matches "device sdb has partitions that will not be formatted. Skipping device."
+	partitionedDeviceRegex = regexp.MustCompile(`device (\S+) (has partitions that will not be formatted)`)
+	// matches the ip of an osd address such as "10.0.0.5:6801/1234" or "[v2:10.0.0.5:6802/1234,v1:10.0.0.5:6803/1234]"
+	osdAddrIPRegex = regexp.MustCompile(`(\d+\.\d+\.\d+\.\d+):`)
+)
+
+func (h *CephInstaller) osdPrepareTimeout() time.Duration {
+	if h.OSDPrepareTimeout <= 0 {
+		return DefaultOSDPrepareTimeout
+	}
+	return h.OSDPrepareTimeout
+}
+
+// WaitForOSDPrepareJobs waits until all the osd prepare jobs of the cluster have completed, giving up after the
+// osd prepare timeout of the installer. The status of each job is returned in the error on timeout.
+func (h *CephInstaller) WaitForOSDPrepareJobs(namespace string) error {
+	timeout := h.osdPrepareTimeout()
+	start := time.Now()
+	status := ""
+	for {
+		jobs, err := h.k8shelper.Clientset.BatchV1().Jobs(namespace).List(metav1.ListOptions{LabelSelector: osdPrepareLabel})
+		if err != nil {
+			return fmt.Errorf("failed to list the osd prepare jobs. %+v", err)
+		}
+		var done bool
+		done, status = osdPrepareJobsComplete(jobs.Items)
+		if done {
+			logger.Infof("osd prepare jobs completed: %s", status)
+			return nil
+		}
+		if time.Since(start) > timeout {
+			return fmt.Errorf("gave up after %v waiting for the osd prepare jobs to complete: %s", timeout, status)
+		}
+		logger.Infof("waiting for the osd prepare jobs to complete: %s", status)
+		time.Sleep(utils.RetryInterval * time.Second)
+	}
+}
+
+// osdPrepareJobsComplete returns whether there is at least one prepare job and all of them succeeded, and a
+// summary of the status of each job
+func osdPrepareJobsComplete(jobs []batch.Job) (bool, string) {
+	if len(jobs) == 0 {
+		return false, "no osd prepare jobs found"
+	}
+
+	done := true
+	var status []string
+	for _, job := range jobs {
+		if job.Status.Succeeded == 0 {
+			done = false
+		}
+		status = append(status, fmt.Sprintf("%s(active=%d, succeeded=%d, failed=%d)",
+			job.Name, job.Status.Active, job.Status.Succeeded, job.Status.Failed))
+	}
+	sort.Strings(status)
+	return done, strings.Join(status, ", ")
+}
+
+// waitForOSDs waits for the osd prepare jobs and the osds of the new cluster, and confirms the osds run on the storage
+// nodes
+func (h *CephInstaller) waitForOSDs(namespace string, storageNodes []string) error {
+	if err := h.WaitForOSDPrepareJobs(namespace); err != nil {
+		return err
+	}
+	if err := h.k8shelper.WaitForPodCount("app=rook-ceph-osd", namespace, 1); err != nil {
+		return err
+	}
+	if err := h.k8shelper.WaitForLabeledPodsToRun("app=rook-ceph-osd", namespace); err != nil {
+		return err
+	}
+	return h.VerifyOSDNodes(namespace, storageNodes)
+}
+
+// VerifyNoOSDsCreated waits for the mgr of a cluster created without osds and confirms the operator did not start an
+// osd
+func (h *CephInstaller) VerifyNoOSDsCreated(namespace string) error {
+	if err := h.k8shelper.WaitForPodCount("app=rook-ceph-mgr", namespace, 1); err != nil {
+		return err
+	}
+	pods, err := h.k8shelper.Clientset.CoreV1().Pods(namespace).List(metav1.ListOptions{LabelSelector: "app=rook-ceph-osd"})
+	if err != nil {
+		return fmt.Errorf("failed to list the osd pods. %+v", err)
+	}
+	if len(pods.Items) > 0 {
+		var names []string
+		for _, pod := range pods.Items {
+			names = append(names, pod.Name)
+		}
+		return fmt.Errorf("osds %v were created in cluster %s without storage nodes", names, namespace)
+	}
+	logger.Infof("no osds were created in cluster %s", namespace)
+	return nil
+}
+
+// VerifyOSDNodes confirms that the osd pods are only running on the nodes with the given hostnames. Nothing is
+// checked if the list is empty since the osds may run on any node.
+func (h *CephInstaller) VerifyOSDNodes(namespace string, hostnames []string) error {
+	if len(hostnames) == 0 {
+		return nil
+	}
+	pods, err := h.k8shelper.Clientset.CoreV1().Pods(namespace).List(metav1.ListOptions{LabelSelector: "app=rook-ceph-osd"})
+	if err != nil {
+		return fmt.Errorf("failed to list the osd pods. %+v", err)
+	}
+	expected := map[string]bool{}
+	for _, hostname := range hostnames {
+		expected[hostname] = true
+	}
+
+	for _, pod := range pods.Items {
+		node, err := h.k8shelper.Clientset.CoreV1().Nodes().Get(pod.Spec.NodeName, metav1.GetOptions{})
+		if err != nil {
+			return fmt.Errorf("failed to get node %s of osd pod %s. %+v", pod.Spec.NodeName, pod.Name, err)
+		}
+		if hostname := node.Labels[apis.LabelHostname]; !expected[hostname] {
+			return fmt.Errorf("osd pod %s is running on node %s which is not one of the storage nodes %v", pod.Name, hostname, hostnames)
+		}
+	}
+	logger.Infof("all %d osd pods are running on the storage nodes %v", len(pods.Items), hostnames)
+	return nil
+}
+
+// GetOSDIDs returns the ids of all the osds in the cluster
+func (h *CephInstaller) GetOSDIDs(namespace string) ([]int, error) {
+	output, err := h.execCephCommand(namespace, "osd", "ls")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list osds. %+v", err)
+	}
+	var ids []int
+	if err := json.Unmarshal(output, &ids); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal osd ls response: %s. %+v", string(output), err)
+	}
+	return ids, nil
+}
+
+// VerifyOSDWeights confirms that the crush weight of each osd is proportional to its capacity as reported by
+// "ceph osd df tree". The osds with a zero weight or a weight that deviates from the median weight per capacity
+// are returned in the error.
+func (h *CephInstaller) VerifyOSDWeights(namespace string) error {
+	output, err := h.execCephCommand(namespace, "osd", "df", "tree")
+	if err != nil {
+		return fmt.Errorf("failed to get osd df tree. %+v", err)
+	}
+	wrong, err := osdsWithWrongWeights(output)
+	if err != nil {
+		return err
+	}
+	if len(wrong) > 0 {
+		return fmt.Errorf("osd weights are not proportional to their capacity: %s", strings.Join(wrong, ", "))
+	}
+	logger.Infof("all osd weights in namespace %s are proportional to their capacity", namespace)
+	return nil
+}
+
+// osdsWithWrongWeights parses the json output of "ceph osd df tree" and returns a description of the osds whose
+// crush weight per kb deviates more than osdWeightTolerance from the median of all the osds
+func osdsWithWrongWeights(dfJSON []byte) ([]string, error) {
+	var df struct {
+		Nodes []struct {
+			ID          int     `json:"id"`
+			Name        string  `json:"name"`
+			Type        string  `json:"type"`
+			CrushWeight float64 `json:"crush_weight"`
+			KB          uint64  `json:"kb"`
+		} `json:"nodes"`
+	}
+	if err := json.Unmarshal(dfJSON, &df); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal osd df tree: %s. %+v", string(dfJSON), err)
+	}
+
+	var wrong []string
+	ratios := map[string]float64{}
+	var sorted []float64
+	for _, node := range df.Nodes {
+		if node.Type != "osd" {
+			continue
+		}
+		if node.CrushWeight <= 0 || node.KB == 0 {
+			wrong = append(wrong, fmt.Sprintf("%s(weight=%g, kb=%d)", node.Name, node.CrushWeight, node.KB))
+			continue
+		}
+		ratios[node.Name] = node.CrushWeight / float64(node.KB)
+		sorted = append(sorted, ratios[node.Name])
+	}
+	if len(sorted) == 0 {
+		return wrong, nil
+	}
+
+	sort.Float64s(sorted)
+	median := sorted[len(sorted)/2]
+	for _, node := range df.Nodes {
+		ratio, ok := ratios[node.Name]
+		if !ok {
+			continue
+		}
+		if deviation := (ratio - median) / median; deviation > osdWeightTolerance || deviation < -osdWeightTolerance {
+			wrong = append(wrong, fmt.Sprintf("%s(weight=%g, kb=%d)", node.Name, node.CrushWeight, node.KB))
+		}
+	}
+	return wrong, nil
+}
+
+// VerifyAllDevicesConsumed confirms that the expected number of devices were turned into osds and that the osd
+// prepare jobs did not skip any device. Osds created on directories are also counted by ceph, so the cluster is
+// expected to have at least as many osds as devices.
+func (h *CephInstaller) VerifyAllDevicesConsumed(namespace string, expectedDevices int) error {
+	ids, err := h.GetOSDIDs(namespace)
+	if err != nil {
+		return err
+	}
+
+	skipped, err := h.getSkippedDevices(namespace)
+	if err != nil {
+		return err
+	}
+
+	if len(skipped) > 0 {
+		return fmt.Errorf("found %d osds for %d devices, but devices were skipped: %s", len(ids), expectedDevices, formatSkippedDevices(skipped))
+	}
+	if len(ids) < expectedDevices {
+		return fmt.Errorf("expected at least %d osds for the devices, but found %d: %v", expectedDevices, len(ids), ids)
+	}
+
+	logger.Infof("all %d devices were consumed by the %d osds", expectedDevices, len(ids))
+	return nil
+}
+
+// getSkippedDevices scans the osd prepare job logs for devices that were not provisioned. The result maps
+// "<prepare pod>/<device>" to the reason the device was skipped.
+func (h *CephInstaller) getSkippedDevices(namespace string) (map[string]string, error) {
+	logs, err := h.k8shelper.GetPodLogsWithLabel(osdPrepareLabel, namespace, "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the osd prepare logs. %+v", err)
+	}
+
+	skipped := map[string]string{}
+	for pod, log := range logs {
+		for device, reason := range parseSkippedDevices(log) {
+			skipped[fmt.Sprintf("%s/%s", pod, device)] = reason
+		}
+	}
+	return skipped, nil
+}
+
+// parseSkippedDevices returns the devices that the osd prepare log reports as skipped, with the reason
+func parseSkippedDevices(log string) map[string]string {
+	skipped := map[string]string{}
+	for _, line := range strings.Split(log, "\n") {
+		for _, r := range []*regexp.Regexp{skippedDeviceRegex, partitionedDeviceRegex} {
+			if match := r.FindStringSubmatch(line); match != nil {
+				skipped[match[1]] = strings.TrimSpace(match[2])
+				break
+			}
+		}
+	}
+	return skipped
+}
+
+func formatSkippedDevices(skipped map[string]string) string {
+	var result []string
+	for device, reason := range skipped {
+		result = append(result, fmt.Sprintf("%s (%s)", device, reason))
+	}
+	sort.Strings(result)
+	return strings.Join(result, ", ")
+}
+
+// RemoveDeviceAndVerify removes the device from the node in the cluster CR, then waits for the osd on the device to
+// be purged from "ceph osd tree" and for all the pgs to be active+clean again. The operator and osd logs are
+// collected if the osd is not removed before the timeout.
+func (h *CephInstaller) RemoveDeviceAndVerify(namespace, nodeName, device string) error {
+	id, err := h.findOSDOnDevice(namespace, nodeName, device)
+	if err != nil {
+		return err
+	}
+	logger.Infof("removing device %s of osd.%d from node %s", device, id, nodeName)
+	if err := h.removeDeviceFromCluster(namespace, nodeName, device); err != nil {
+		return err
+	}
+
+	if err := h.waitForOSDRemoved(namespace, id); err != nil {
+		h.GatherAllRookLogs(namespace, SystemNamespace(namespace), "remove-device-"+device)
+		return err
+	}
+	return h.waitForCleanPGs(namespace)
+}
+
+// RemoveNodeAndVerify removes the node from the storage nodes in the cluster CR, then waits for the osds of the node to
+// be purged from "ceph osd tree" and their deployments to be deleted. The cluster must then be healthy with the osds of
+// the node less than before, and the osd counts before and after the removal are logged. The rook logs are collected if
+// the osds are not removed cleanly.
+func (h *CephInstaller) RemoveNodeAndVerify(namespace, nodeName string) error {
+	before, err := h.GetOSDIDs(namespace)
+	if err != nil {
+		return err
+	}
+	ids, err := h.osdIDsOnNode(namespace, nodeName)
+	if err != nil {
+		return err
+	}
+	if len(ids) == 0 {
+		return fmt.Errorf("no osds found on node %s", nodeName)
+	}
+	logger.Infof("removing node %s with osds %v", nodeName, ids)
+	if err := h.removeNodeFromCluster(namespace, nodeName); err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		err := h.waitForOSDRemoved(namespace, id)
+		if err == nil {
+			err = h.waitForOSDDeploymentRemoved(namespace, id)
+		}
+		if err != nil {
+			h.GatherAllRookLogs(namespace, SystemNamespace(namespace), "remove-node-"+nodeName)
+			return err
+		}
+	}
+	if err := h.waitForCleanPGs(namespace); err != nil {
+		return err
+	}
+	if err := h.verifyCephHealthy(namespace); err != nil {
+		return fmt.Errorf("cluster is not healthy after the removal of node %s. %+v", nodeName, err)
+	}
+
+	after, err := h.GetOSDIDs(namespace)
+	if err != nil {
+		return err
+	}
+	logger.Infof("removed node %s. osd count before=%d, after=%d", nodeName, len(before), len(after))
+	if len(after) != len(before)-len(ids) {
+		h.GatherAllRookLogs(namespace, SystemNamespace(namespace), "remove-node-"+nodeName)
+		return fmt.Errorf("expected %d osds after the removal of node %s, found %d: %v", len(before)-len(ids), nodeName, len(after), after)
+	}
+	return nil
+}
+
+// RemoveOSDGracefully marks the osd out so that its pgs migrate to the other osds before the operator purges it, which
+// requires the cluster to remove the osds that are out and safe to remove. The pgs on the osd and the pg states are
+// tracked until the osd is purged, and the transitions are logged and returned in the error. The rook logs are
+// collected if the osd is purged while pgs are still on it. The pgs must be active+clean after the removal.
+func (h *CephInstaller) RemoveOSDGracefully(namespace string, osdID int) error {
+	logger.Infof("marking osd.%d out for a graceful removal", osdID)
+	if _, err := h.execCephCommand(namespace, "osd", "out", strconv.Itoa(osdID)); err != nil {
+		return fmt.Errorf("failed to mark osd.%d out. %+v", osdID, err)
+	}
+
+	context := h.k8shelper.MakeContext()
+	start := time.Now()
+	var transitions []string
+	lastState := ""
+	// the pgs on the osd are unknown until the pg distribution was read once
+	lastPGs := 0
+	sampled := false
+	for {
+		output, err := h.execCephCommand(namespace, "osd", "tree")
+		if err == nil {
+			var found bool
+			if found, err = osdInTree(output, osdID); err == nil && !found {
+				break
+			}
+		}
+		if distribution, distErr := h.GetPGDistribution(namespace); distErr == nil {
+			if pgs, ok := distribution[osdID]; ok {
+				lastPGs = pgs
+				sampled = true
+			}
+		}
+		if status, statusErr := client.Status(context, namespace); statusErr == nil {
+			pgs := "unknown"
+			if sampled {
+				pgs = strconv.Itoa(lastPGs)
+			}
+			state := fmt.Sprintf("osd.%d pgs=%s, %s", osdID, pgs, formatPGStates(status.PgMap.PgsByState))
+			if state != lastState {
+				transitions = append(transitions, fmt.Sprintf("%v: %s", time.Since(start).Round(time.Second), state))
+				logger.Infof("pg transition during the removal of osd.%d: %s", osdID, state)
+				lastState = state
+			}
+		}
+		if time.Since(start) > osdRemovalTimeout {
+			h.GatherAllRookLogs(namespace, SystemNamespace(namespace), fmt.Sprintf("graceful-removal-osd-%d", osdID))
+			return fmt.Errorf("gave up after %v waiting for osd.%d to be removed. transitions: %s. %v", osdRemovalTimeout, osdID, strings.Join(transitions, "; "), err)
+		}
+		time.Sleep(utils.RetryInterval * time.Second)
+	}
+
+	logger.Infof("osd.%d was removed after %v. transitions: %s", osdID, time.Since(start), strings.Join(transitions, "; "))
+	if !sampled {
+		h.GatherAllRookLogs(namespace, SystemNamespace(namespace), fmt.Sprintf("graceful-removal-osd-%d", osdID))
+		return fmt.Errorf("osd.%d was removed before the pgs on it could be counted. transitions: %s", osdID, strings.Join(transitions, "; "))
+	}
+	if lastPGs != 0 {
+		h.GatherAllRookLogs(namespace, SystemNamespace(namespace), fmt.Sprintf("graceful-removal-osd-%d", osdID))
+		return fmt.Errorf("osd.%d was removed while %d pgs were still on it. transitions: %s", osdID, lastPGs, strings.Join(transitions, "; "))
+	}
+	if err := h.waitForOSDDeploymentRemoved(namespace, osdID); err != nil {
+		return err
+	}
+	if err := h.waitForCleanPGs(namespace); err != nil {
+		return fmt.Errorf("pgs are not active+clean after the removal of osd.%d. transitions: %s. %+v", osdID, strings.Join(transitions, "; "), err)
+	}
+	return nil
+}
+
+// formatPGStates returns the pg states with their counts sorted by state, such as "active+clean=32"
+func formatPGStates(states []client.PgStateEntry) string {
+	var result []string
+	for _, state := range states {
+		result = append(result, fmt.Sprintf("%s=%d", state.StateName, state.Count))
+	}
+	sort.Strings(result)
+	return strings.Join(result, ", ")
+}
+
+// waitForOSDDeploymentRemoved waits until the deployment of the osd is deleted
+func (h *CephInstaller) waitForOSDDeploymentRemoved(namespace string, id int) error {
+	selector := fmt.Sprintf("app=rook-ceph-osd,%s=%d", osdIDLabel, id)
+	start := time.Now()
+	for {
+		deployments, err := h.k8shelper.Clientset.ExtensionsV1beta1().Deployments(namespace).List(metav1.ListOptions{LabelSelector: selector})
+		if err == nil && len(deployments.Items) == 0 {
+			logger.Infof("the deployment of osd.%d was removed", id)
+			return nil
+		}
+		if time.Since(start) > osdRemovalTimeout {
+			return fmt.Errorf("gave up after %v waiting for the deployment of osd.%d to be removed. %v", osdRemovalTimeout, id, err)
+		}
+		logger.Infof("waiting for the deployment of osd.%d to be removed. %v", id, err)
+		time.Sleep(utils.RetryInterval * time.Second)
+	}
+}
+
+// AddDeviceAndMeasure adds the device to the storage config of the node in the cluster CR and waits for the new osd to
+// be up and in. The time from the update of the cluster until the osd joined is returned. The osd prepare logs are
+// collected if no osd appears within the osd prepare timeout.
+func (h *CephInstaller) AddDeviceAndMeasure(namespace, nodeName, device string) (time.Duration, error) {
+	before, err := h.GetOSDIDs(namespace)
+	if err != nil {
+		return 0, err
+	}
+
+	logger.Infof("adding device %s of node %s to the cluster", device, nodeName)
+	start := time.Now()
+	if err := h.addDeviceToCluster(namespace, nodeName, device); err != nil {
+		return 0, err
+	}
+
+	timeout := h.osdPrepareTimeout()
+	for {
+		output, err := h.execCephCommand(namespace, "osd", "dump")
+		if err == nil {
+			var ids []int
+			ids, err = newOSDsUpAndIn(output, before)
+			if err == nil && len(ids) > 0 {
+				elapsed := time.Since(start)
+				logger.Infof("osd %v on device %s of node %s joined after %v", ids, device, nodeName, elapsed)
+				return elapsed, nil
+			}
+		}
+		if time.Since(start) > timeout {
+			h.k8shelper.GetRookLogs("rook-ceph-osd-prepare", Env.HostType, namespace, "add-device-"+device)
+			return 0, fmt.Errorf("gave up after %v waiting for an osd on device %s of node %s. %v", timeout, device, nodeName, err)
+		}
+		logger.Infof("waiting for an osd on device %s of node %s. %v", device, nodeName, err)
+		time.Sleep(utils.RetryInterval * time.Second)
+	}
+}
+
+// addDeviceToCluster updates the cluster CR with the device in the storage config of the node. The node is added to
+// the storage nodes if it is not listed yet.
+func (h *CephInstaller) addDeviceToCluster(namespace, nodeName, device string) error {
+	cluster, err := h.k8shelper.RookClientset.CephV1().CephClusters(namespace).Get(namespace, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to get the cluster in namespace %s. %+v", namespace, err)
+	}
+
+	i := -1
+	for j, node := range cluster.Spec.Storage.Nodes {
+		if node.Name == nodeName {
+			i = j
+			break
+		}
+	}
+	if i < 0 {
+		cluster.Spec.Storage.Nodes = append(cluster.Spec.Storage.Nodes, rookalpha.Node{Name: nodeName})
+		i = len(cluster.Spec.Storage.Nodes) - 1
+	}
+	for _, d := range cluster.Spec.Storage.Nodes[i].Devices {
+		if d.Name == device {
+			return fmt.Errorf("device %s of node %s is already in the storage config of the cluster", device, nodeName)
+		}
+	}
+	cluster.Spec.Storage.Nodes[i].Devices = append(cluster.Spec.Storage.Nodes[i].Devices, rookalpha.Device{Name: device})
+
+	if _, err := h.k8shelper.RookClientset.CephV1().CephClusters(namespace).Update(cluster); err != nil {
+		return fmt.Errorf("failed to update the cluster in namespace %s. %+v", namespace, err)
+	}
+	return nil
+}
+
+// AddOSDsAndVerifyRebalance adds the devices, keyed by node name, to the storage config of the cluster CR, waits for
+// the new osds to be up and in, and confirms that the pgs rebalance onto them. The data is considered rebalanced when
+// the pgs are active+clean and each new osd holds at least half of the average pgs per osd. The pg distribution per
+// osd before and after is logged and included in the error, together with the ceph status on failure.
+func (h *CephInstaller) AddOSDsAndVerifyRebalance(namespace string, additionalDevices map[string]string) error {
+	before, err := h.GetOSDIDs(namespace)
+	if err != nil {
+		return err
+	}
+	distributionBefore, err := h.GetPGDistribution(namespace)
+	if err != nil {
+		return err
+	}
+	logger.Infof("pg distribution before adding the osds: %v", distributionBefore)
+
+	var nodes []string
+	for node := range additionalDevices {
+		nodes = append(nodes, node)
+	}
+	sort.Strings(nodes)
+	for _, node := range nodes {
+		if err := h.addDeviceToCluster(namespace, node, additionalDevices[node]); err != nil {
+			return err
+		}
+	}
+
+	newIDs, err := h.waitForNewOSDs(namespace, before, len(additionalDevices))
+	if err == nil {
+		err = h.waitForRebalance(namespace, newIDs)
+	}
+	distributionAfter, distErr := h.GetPGDistribution(namespace)
+	if distErr != nil {
+		logger.Warningf("failed to get the pg distribution after adding the osds. %+v", distErr)
+	}
+	logger.Infof("pg distribution after adding osds %v: %v", newIDs, distributionAfter)
+	if err != nil {
+		if status, statusErr := h.execCephCommand(namespace, "status"); statusErr == nil {
+			logger.Infof("ceph status of cluster %s:\n%s", namespace, string(status))
+		}
+		return fmt.Errorf("data did not rebalance onto the new osds %v. pgs before: %v, pgs after: %v. %+v", newIDs, distributionBefore, distributionAfter, err)
+	}
+	logger.Infof("data rebalanced onto the new osds %v", newIDs)
+	return nil
+}
+
+// GetPGDistribution returns the number of pgs of each osd, keyed by osd id
+func (h *CephInstaller) GetPGDistribution(namespace string) (map[int]int, error) {
+	usage, err := client.GetOSDUsage(h.k8shelper.MakeContext(), namespace)
+	if err != nil {
+		return nil, err
+	}
+	distribution := map[int]int{}
+	for _, osd := range usage.OSDNodes {
+		pgs, err := osd.Pgs.Int64()
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse the pgs %q of osd %d. %+v", osd.Pgs, osd.ID, err)
+		}
+		distribution[osd.ID] = int(pgs)
+	}
+	return distribution, nil
+}
+
+// GetClusterCapacity returns the total, used and available raw capacity of the cluster in bytes from "ceph df". The
+// raw output is returned in the error if it does not report the capacity.
+func (h *CephInstaller) GetClusterCapacity(namespace string) (total, used, available int64, err error) {
+	output, err := h.execCephCommand(namespace, "df")
+	if err != nil {
+		return 0, 0, 0, fmt.Errorf("failed to get ceph df. %+v", err)
+	}
+	return parseClusterCapacity(output)
+}
+
+func parseClusterCapacity(dfJSON []byte) (total, used, available int64, err error) {
+	var df struct {
+		Stats struct {
+			TotalBytes     *int64 `json:"total_bytes"`
+			TotalUsedBytes *int64 `json:"total_used_bytes"`
+			TotalAvailable *int64 `json:"total_avail_bytes"`
+		} `json:"stats"`
+	}
+	if err := json.Unmarshal(dfJSON, &df); err != nil {
+		return 0, 0, 0, fmt.Errorf("failed to unmarshal ceph df: %s. %+v", string(dfJSON), err)
+	}
+	if df.Stats.TotalBytes == nil || df.Stats.TotalUsedBytes == nil || df.Stats.TotalAvailable == nil {
+		return 0, 0, 0, fmt.Errorf("ceph df does not report the capacity of the cluster: %s", string(dfJSON))
+	}
+	return *df.Stats.TotalBytes, *df.Stats.TotalUsedBytes, *df.Stats.TotalAvailable, nil
+}
+
+// waitForNewOSDs waits for the expected number of new osds to be up and in within the osd prepare timeout
+func (h *CephInstaller) waitForNewOSDs(namespace string, existing []int, expected int) ([]int, error) {
+	start := time.Now()
+	timeout := h.osdPrepareTimeout()
+	var ids []int
+	for {
+		output, err := h.execCephCommand(namespace, "osd", "dump")
+		if err == nil {
+			ids, err = newOSDsUpAndIn(output, existing)
+			if err == nil && len(ids) >= expected {
+				logger.Infof("new osds %v are up and in after %v", ids, time.Since(start))
+				return ids, nil
+			}
+		}
+		if time.Since(start) > timeout {
+			h.k8shelper.GetRookLogs("rook-ceph-osd-prepare", Env.HostType, namespace, "add-osds")
+			return ids, fmt.Errorf("gave up after %v waiting for %d new osds, found %v. %v", timeout, expected, ids, err)
+		}
+		logger.Infof("waiting for %d new osds, found %v. %v", expected, ids, err)
+		time.Sleep(utils.RetryInterval * time.Second)
+	}
+}
+
+// waitForRebalance waits for the pgs to be active+clean with the new osds holding their share of the pgs
+func (h *CephInstaller) waitForRebalance(namespace string, newIDs []int) error {
+	var err error
+	for i := 0; i < utils.RetryLoop; i++ {
+		if err = h.waitForCleanPGs(namespace); err != nil {
+			return err
+		}
+		var distribution map[int]int
+		if distribution, err = h.GetPGDistribution(namespace); err == nil {
+			uneven := osdsBelowPGShare(distribution, newIDs)
+			if len(uneven) == 0 {
+				return nil
+			}
+			err = fmt.Errorf("osds with too few pgs: %s", strings.Join(uneven, ", "))
+		}
+		logger.Infof("waiting for the data to rebalance. %v", err)
+		time.Sleep(utils.RetryInterval * time.Second)
+	}
+	return fmt.Errorf("gave up waiting for the data to rebalance. %+v", err)
+}
+
+// osdsBelowPGShare returns a description of the osds among the ids that hold less than osdRebalanceMinShare of the
+// average pgs per osd of the distribution
+func osdsBelowPGShare(distribution map[int]int, ids []int) []string {
+	if len(distribution) == 0 {
+		return nil
+	}
+	total := 0
+	for _, pgs := range distribution {
+		total += pgs
+	}
+	average := float64(total) / float64(len(distribution))
+	var below []string
+	for _, id := range ids {
+		pgs, ok := distribution[id]
+		if !ok {
+			below = append(below, fmt.Sprintf("osd.%d is not in the distribution", id))
+		} else if float64(pgs) < average*osdRebalanceMinShare {
+			below = append(below, fmt.Sprintf("osd.%d has %d pgs of %.1f on average", id, pgs, average))
+		}
+	}
+	return below
+}
+
+// newOSDsUpAndIn returns the osds in the json output of "ceph osd dump" that are up and in and not among the
+// existing osds
+func newOSDsUpAndIn(dumpJSON []byte, existing []int) ([]int, error) {
+	var dump struct {
+		OSDs []struct {
+			OSD int `json:"osd"`
+			Up  int `json:"up"`
+			In  int `json:"in"`
+		} `json:"osds"`
+	}
+	if err := json.Unmarshal(dumpJSON, &dump); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal osd dump: %s. %+v", string(dumpJSON), err)
+	}
+	known := map[int]bool{}
+	for _, id := range existing {
+		known[id] = true
+	}
+	var ids []int
+	for _, osd := range dump.OSDs {
+		if !known[osd.OSD] && osd.Up == 1 && osd.In == 1 {
+			ids = append(ids, osd.OSD)
+		}
+	}
+	return ids, nil
+}
+
+// RotateOSDEncryptionKeys rotates the dm-crypt keys of the encrypted osds, which requires the installer to create the
+// cluster with encrypted devices. For each osd a new luks passphrase is added on its node, the old passphrase is removed
+// and the new one is stored in the config-key store of the mons, where ceph-disk and ceph-volume read the key when the
+// osd is activated. The osd is restarted to confirm it opens its device with the new key. An object written before the
+// rotation must still be readable afterwards. The osd logs are collected on failure.
+func (h *CephInstaller) RotateOSDEncryptionKeys(namespace string) error {
+	if !h.Cluster.EncryptedDevices {
+		return fmt.Errorf("the osds in namespace %s are not encrypted", namespace)
+	}
+	osds, err := h.encryptedOSDs(namespace)
+	if err != nil {
+		return err
+	}
+	if len(osds) == 0 {
+		return fmt.Errorf("no encrypted osds found in namespace %s", namespace)
+	}
+
+	if err := h.CreateBlockPools(namespace, []PoolSpec{{Name: keyRotationPool, Replicas: 1}}); err != nil {
+		return err
+	}
+	defer func() {
+		if _, err := h.k8shelper.DeleteResource("-n", namespace, "CephBlockPool", keyRotationPool); err != nil {
+			logger.Warningf("failed to delete pool %s. %+v", keyRotationPool, err)
+		}
+	}()
+	if _, err := h.k8shelper.Exec(namespace, "rook-ceph-tools", "rados", []string{"-p", keyRotationPool, "put", keyRotationObject, "/etc/hostname"}); err != nil {
+		return fmt.Errorf("failed to write to pool %s. %+v", keyRotationPool, err)
+	}
+
+	for _, osd := range osds {
+		if err := h.rotateOSDKey(namespace, osd); err != nil {
+			h.k8shelper.GetRookLogs("rook-ceph-osd", Env.HostType, namespace, fmt.Sprintf("rotate-key-osd-%d", osd.id))
+			return err
+		}
+	}
+	if err := h.waitForCleanPGs(namespace); err != nil {
+		h.k8shelper.GetRookLogs("rook-ceph-osd", Env.HostType, namespace, "rotate-keys")
+		return err
+	}
+
+	// the object is compared with the file it was written from
+	if _, err := h.k8shelper.Exec(namespace, "rook-ceph-tools", "sh", []string{"-c",
+		fmt.Sprintf("rados -p %s get %s /tmp/%s && cmp /etc/hostname /tmp/%s", keyRotationPool, keyRotationObject, keyRotationObject, keyRotationObject)}); err != nil {
+		h.k8shelper.GetRookLogs("rook-ceph-osd", Env.HostType, namespace, "rotate-keys")
+		return fmt.Errorf("object written before the key rotation is not readable. %+v", err)
+	}
+	logger.Infof("rotated the keys of %d osds in namespace %s", len(osds), namespace)
+	return nil
+}
+
+// encryptedOSD is an osd whose data device is opened with dm-crypt
+type encryptedOSD struct {
+	id   int
+	uuid string
+	// the dm-crypt device of the osd data
+	devicePath string
+}
+
+// encryptedOSDs returns the osds with a dm-crypt data device
+func (h *CephInstaller) encryptedOSDs(namespace string) ([]encryptedOSD, error) {
+	output, err := h.execCephCommand(namespace, "osd", "dump")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the osd dump. %+v", err)
+	}
+	uuids, err := osdUUIDs(output)
+	if err != nil {
+		return nil, err
+	}
+
+	var osds []encryptedOSD
+	for id, uuid := range uuids {
+		output, err := h.execCephCommand(namespace, "osd", "metadata", strconv.Itoa(id))
+		if err != nil {
+			return nil, fmt.Errorf("failed to get the metadata of osd.%d. %+v", id, err)
+		}
+		var metadata map[string]interface{}
+		if err := json.Unmarshal(output, &metadata); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal the metadata of osd.%d: %s. %+v", id, string(output), err)
+		}
+		if devicePath := dmcryptDevicePath(metadata); devicePath != "" {
+			osds = append(osds, encryptedOSD{id: id, uuid: uuid, devicePath: devicePath})
+		}
+	}
+	sort.Slice(osds, func(i, j int) bool { return osds[i].id < osds[j].id })
+	return osds, nil
+}
+
+// osdUUIDs returns the uuids of the osds in the json output of "ceph osd dump", keyed by the osd id
+func osdUUIDs(dumpJSON []byte) (map[int]string, error) {
+	var dump struct {
+		OSDs []struct {
+			OSD  int    `json:"osd"`
+			UUID string `json:"uuid"`
+		} `json:"osds"`
+	}
+	if err := json.Unmarshal(dumpJSON, &dump); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal osd dump: %s. %+v", string(dumpJSON), err)
+	}
+	uuids := map[int]string{}
+	for _, osd := range dump.OSDs {
+		uuids[osd.OSD] = osd.UUID
+	}
+	return uuids, nil
+}
+
+// dmcryptDevicePath returns the device mapper path of the bluestore or filestore data of the osd metadata, or an empty
+// string if the data is not on a mapped device
+func dmcryptDevicePath(metadata map[string]interface{}) string {
+	for _, key := range []string{"bluestore_bdev_partition_path", "backend_filestore_partition_path"} {
+		if p, ok := metadata[key].(string); ok && (strings.HasPrefix(p, "/dev/mapper/") || strings.HasPrefix(p, "/dev/dm-")) {
+			return p
+		}
+	}
+	return ""
+}
+
+// rotateOSDKey replaces the luks passphrase of the osd and restarts the osd with the new passphrase
+func (h *CephInstaller) rotateOSDKey(namespace string, osd encryptedOSD) error {
+	keyName := luksKeyName(osd.uuid)
+	output, err := h.execCephCommand(namespace, "config-key", "get", keyName)
+	if err != nil {
+		return fmt.Errorf("failed to get the key of osd.%d. %+v", osd.id, err)
+	}
+	oldKey := configKeyValue(output)
+	newKey, err := newLUKSKey()
+	if err != nil {
+		return err
+	}
+
+	selector := fmt.Sprintf("app=rook-ceph-osd,%s=%d", osdIDLabel, osd.id)
+	pods, err := h.k8shelper.Clientset.CoreV1().Pods(namespace).List(metav1.ListOptions{LabelSelector: selector})
+	if err != nil || len(pods.Items) == 0 {
+		return fmt.Errorf("failed to find the pod of osd.%d. %v", osd.id, err)
+	}
+	nodeName := pods.Items[0].Spec.NodeName
+
+	logger.Infof("rotating the key of osd.%d on node %s", osd.id, nodeName)
+	name := fmt.Sprintf("rook-rotate-key-osd-%d", osd.id)
+	if _, err := h.runOnNode(namespace, nodeName, name, "sh", "-c", renderKeyRotationScript(osd.devicePath, oldKey, newKey)); err != nil {
+		return fmt.Errorf("failed to rotate the luks key of osd.%d. %+v", osd.id, err)
+	}
+	if _, err := h.execCephCommand(namespace, "config-key", "set", keyName, newKey); err != nil {
+		return fmt.Errorf("failed to store the new key of osd.%d, which cannot be activated until the key is stored. %+v", osd.id, err)
+	}
+
+	if _, err := h.restartPods(namespace, selector); err != nil {
+		return fmt.Errorf("osd.%d did not restart with the new key. %+v", osd.id, err)
+	}
+	return h.waitForOSDUp(namespace, osd.id)
+}
+
+// luksKeyName returns the name of the luks passphrase of the osd in the config-key store of the mons
+func luksKeyName(uuid string) string {
+	return fmt.Sprintf("dm-crypt/osd/%s/luks", uuid)
+}
+
+// configKeyValue returns the value of a "ceph config-key get" response, which is a json string when json output is
+// requested by some releases and the raw value by others
+func configKeyValue(output []byte) string {
+	var value string
+	if err := json.Unmarshal(output, &value); err == nil {
+		return value
+	}
+	return strings.TrimSpace(string(output))
+}
+
+// newLUKSKey returns a random base64 passphrase of the same size as the keys generated by ceph-volume
+func newLUKSKey() (string, error) {
+	key := make([]byte, luksKeySize)
+	if _, err := cryptorand.Read(key); err != nil {
+		return "", fmt.Errorf("failed to generate a luks key. %+v", err)
+	}
+	return base64.StdEncoding.EncodeToString(key), nil
+}
+
+// renderKeyRotationScript returns the shell script that adds the new passphrase to the luks device behind the mapped
+// device, removes the old passphrase and confirms only the new passphrase opens the device. The passphrases are base64,
+// so single quotes are safe.
+func renderKeyRotationScript(devicePath, oldKey, newKey string) string {
+	return `set -e
+printf '%s' '` + oldKey + `' > /tmp/old-key
+printf '%s' '` + newKey + `' > /tmp/new-key
+mapped=$(readlink -f ` + devicePath + `)
+name=$(cat /sys/block/$(basename $mapped)/dm/name)
+luks=$(cryptsetup status $name | awk '$1 == "device:" {print $2}')
+cryptsetup -q luksAddKey --key-file /tmp/old-key $luks /tmp/new-key
+cryptsetup -q luksRemoveKey $luks /tmp/old-key
+cryptsetup open --test-passphrase --key-file /tmp/new-key $luks
+if cryptsetup open --test-passphrase --key-file /tmp/old-key $luks; then
+  echo "the old key still opens $luks" >&2
+  exit 1
+fi`
+}
+
+// waitForOSDUp waits until the osd is up in the osd map
+func (h *CephInstaller) waitForOSDUp(namespace string, id int) error {
+	var err error
+	for i := 0; i < utils.RetryLoop; i++ {
+		var output []byte
+		if output, err = h.execCephCommand(namespace, "osd", "dump"); err == nil {
+			var up []int
+			if up, err = newOSDsUpAndIn(output, nil); err == nil {
+				for _, upID := range up {
+					if upID == id {
+						logger.Infof("osd.%d is up", id)
+						return nil
+					}
+				}
+			}
+		}
+		logger.Infof("waiting for osd.%d to be up. %v", id, err)
+		time.Sleep(utils.RetryInterval * time.Second)
+	}
+	return fmt.Errorf("gave up waiting for osd.%d to be up. %v", id, err)
+}
+
+// findOSDOnDevice returns the id of the osd that runs on the node with the device
+func (h *CephInstaller) findOSDOnDevice(namespace, nodeName, device string) (int, error) {
+	deployments, err := h.k8shelper.Clientset.ExtensionsV1beta1().Deployments(namespace).List(metav1.ListOptions{LabelSelector: "app=rook-ceph-osd"})
+	if err != nil {
+		return 0, fmt.Errorf("failed to list the osd deployments. %+v", err)
+	}
+	for _, d := range deployments.Items {
+		if d.Spec.Template.Spec.NodeSelector[apis.LabelHostname] != nodeName {
+			continue
+		}
+		id, err := strconv.Atoi(d.Labels[osdIDLabel])
+		if err != nil {
+			continue
+		}
+		output, err := h.execCephCommand(namespace, "osd", "metadata", strconv.Itoa(id))
+		if err != nil {
+			return 0, fmt.Errorf("failed to get the metadata of osd.%d. %+v", id, err)
+		}
+		var metadata map[string]interface{}
+		if err := json.Unmarshal(output, &metadata); err != nil {
+			return 0, fmt.Errorf("failed to unmarshal the metadata of osd.%d: %s. %+v", id, string(output), err)
+		}
+		if osdUsesDevice(metadata, device) {
+			return id, nil
+		}
+	}
+	return 0, fmt.Errorf("no osd found on device %s of node %s", device, nodeName)
+}
+
+// osdUsesDevice returns whether the osd metadata reports the device, either in the device list of newer releases or
+// as the device node of the bluestore or filestore backend
+func osdUsesDevice(metadata map[string]interface{}, device string) bool {
+	device = path.Base(device)
+	if devices, ok := metadata["devices"].(string); ok {
+		for _, d := range strings.Split(devices, ",") {
+			if d == device {
+				return true
+			}
+		}
+	}
+	for _, key := range []string{"bluestore_bdev_dev_node", "backend_filestore_dev_node"} {
+		if node, ok := metadata[key].(string); ok && path.Base(node) == device {
+			return true
+		}
+	}
+	return false
+}
+
+// VerifyOSDMetadataDevice confirms the osd has a dedicated bluestore db on the expected device, and that its wal is
+// either on the same device or kept with the db. The mismatches are reported in the error.
+func (h *CephInstaller) VerifyOSDMetadataDevice(namespace string, osdID int, expectedDevice string) error {
+	output, err := h.execCephCommand(namespace, "osd", "metadata", strconv.Itoa(osdID))
+	if err != nil {
+		return fmt.Errorf("failed to get the metadata of osd.%d. %+v", osdID, err)
+	}
+	var metadata map[string]interface{}
+	if err := json.Unmarshal(output, &metadata); err != nil {
+		return fmt.Errorf("failed to unmarshal the metadata of osd.%d: %s. %+v", osdID, string(output), err)
+	}
+	if mismatches := metadataDeviceMismatches(metadata, expectedDevice); len(mismatches) > 0 {
+		return fmt.Errorf("osd.%d does not use metadata device %s: %s", osdID, expectedDevice, strings.Join(mismatches, "; "))
+	}
+	logger.Infof("osd.%d has its db and wal on %s", osdID, expectedDevice)
+	return nil
+}
+
+// metadataDeviceMismatches compares the bluefs db and wal devices in the osd metadata with the expected device
+func metadataDeviceMismatches(metadata map[string]interface{}, device string) []string {
+	device = path.Base(device)
+	value := func(key string) string {
+		v, _ := metadata[key].(string)
+		return v
+	}
+
+	var mismatches []string
+	if value("bluefs_dedicated_db") != "1" {
+		mismatches = append(mismatches, "the db is not on a dedicated device")
+	} else if db := path.Base(value("bluefs_db_dev_node")); db != device {
+		mismatches = append(mismatches, fmt.Sprintf("the db is on %s", db))
+	}
+	if value("bluefs_dedicated_wal") == "1" {
+		if wal := path.Base(value("bluefs_wal_dev_node")); wal != device {
+			mismatches = append(mismatches, fmt.Sprintf("the wal is on %s", wal))
+		}
+	}
+	return mismatches
+}
+
+// VerifyFormattedDeviceSkipped formats the device of the node with a foreign filesystem, adds it to the storage config
+// of the node in the cluster CR and confirms that the osd prepare job skips the device because of the filesystem. The
+// device must not become an osd and must still have the filesystem afterwards. The device is removed from the storage
+// config of the cluster again, but the filesystem is left on the device.
+func (h *CephInstaller) VerifyFormattedDeviceSkipped(namespace, nodeName, device string) error {
+	if _, err := h.runOnNode(namespace, nodeName, "format-"+device, "mkfs."+foreignFilesystem, "-F", "/dev/"+device); err != nil {
+		return fmt.Errorf("failed to format device %s of node %s. %+v", device, nodeName, err)
+	}
+	if err := h.addDeviceToCluster(namespace, nodeName, device); err != nil {
+		return err
+	}
+	defer func() {
+		if err := h.removeDeviceFromCluster(namespace, nodeName, device); err != nil {
+			logger.Warningf("failed to remove device %s of node %s from the cluster. %+v", device, nodeName, err)
+		}
+	}()
+
+	reason, err := h.waitForDeviceSkipped(namespace, nodeName, device)
+	if err != nil {
+		h.k8shelper.GetRookLogs("rook-ceph-osd-prepare", Env.HostType, namespace, "formatted-device-"+device)
+		return err
+	}
+	if !strings.Contains(reason, foreignFilesystem) {
+		return fmt.Errorf("device %s of node %s was skipped for another reason than its filesystem: %s", device, nodeName, reason)
+	}
+	if id, err := h.findOSDOnDevice(namespace, nodeName, device); err == nil {
+		return fmt.Errorf("osd.%d was created on the formatted device %s of node %s", id, device, nodeName)
+	}
+	output, err := h.runOnNode(namespace, nodeName, "blkid-"+device, "blkid", "-o", "value", "-s", "TYPE", "/dev/"+device)
+	if err != nil {
+		return fmt.Errorf("failed to get the filesystem of device %s of node %s. %+v", device, nodeName, err)
+	}
+	if strings.TrimSpace(output) != foreignFilesystem {
+		return fmt.Errorf("device %s of node %s has filesystem %q instead of %s after the osd prepare job", device, nodeName, strings.TrimSpace(output), foreignFilesystem)
+	}
+	logger.Infof("the osd prepare job skipped the formatted device %s of node %s: %s", device, nodeName, reason)
+	return nil
+}
+
+// waitForDeviceSkipped waits for the osd prepare job of the node to report the device as skipped and returns the reason
+func (h *CephInstaller) waitForDeviceSkipped(namespace, nodeName, device string) (string, error) {
+	timeout := h.osdPrepareTimeout()
+	start := time.Now()
+	for {
+		logs, err := h.osdPrepareLogsOnNode(namespace, nodeName)
+		if err == nil {
+			for _, log := range logs {
+				if reason, ok := parseSkippedDevices(log)[device]; ok {
+					return reason, nil
+				}
+			}
+		}
+		if time.Since(start) > timeout {
+			return "", fmt.Errorf("gave up after %v waiting for the osd prepare job of node %s to skip device %s. %v", timeout, nodeName, device, err)
+		}
+		logger.Infof("waiting for the osd prepare job of node %s to skip device %s. %v", nodeName, device, err)
+		time.Sleep(utils.RetryInterval * time.Second)
+	}
+}
+
+// osdPrepareLogsOnNode returns the logs of the osd prepare pods that ran on the node, keyed by pod name
+func (h *CephInstaller) osdPrepareLogsOnNode(namespace, nodeName string) (map[string]string, error) {
+	logs, err := h.k8shelper.GetPodLogsWithLabel(osdPrepareLabel, namespace, "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the osd prepare logs. %+v", err)
+	}
+	pods, err := h.k8shelper.Clientset.CoreV1().Pods(namespace).List(metav1.ListOptions{LabelSelector: osdPrepareLabel})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the osd prepare pods. %+v", err)
+	}
+	for _, pod := range pods.Items {
+		if pod.Spec.NodeName != nodeName {
+			delete(logs, pod.Name)
+		}
+	}
+	return logs, nil
+}
+
+// runOnNode runs the command in a privileged pod on the node with the devices of the host, and returns the output
+// of the command. The pod runs the image of the operator and is deleted afterwards.
+func (h *CephInstaller) runOnNode(namespace, nodeName, name string, command ...string) (string, error) {
+	operators, err := h.k8shelper.Clientset.CoreV1().Pods(metav1.NamespaceAll).List(metav1.ListOptions{LabelSelector: "app=" + operatorAppName})
+	if err != nil || len(operators.Items) == 0 {
+		return "", fmt.Errorf("operator pod not found. %v", err)
+	}
+	image := operators.Items[0].Spec.Containers[0].Image
+
+	pod := renderNodeCommandPod(namespace, nodeName, name, image, command)
+	if _, err := h.k8shelper.KubectlWithStdin(pod, createFromStdinArgs...); err != nil {
+		return "", fmt.Errorf("failed to create pod %s. %+v", name, err)
+	}
+	defer func() {
+		if _, err := h.k8shelper.KubectlWithStdin(pod, deleteFromStdinArgs...); err != nil {
+			logger.Warningf("failed to delete pod %s. %+v", name, err)
+		}
+	}()
+
+	var phase v1.PodPhase
+	for i := 0; i < utils.RetryLoop; i++ {
+		p, err := h.k8shelper.Clientset.CoreV1().Pods(namespace).Get(name, metav1.GetOptions{})
+		if err != nil {
+			return "", fmt.Errorf("failed to get pod %s. %+v", name, err)
+		}
+		phase = p.Status.Phase
+		if phase == v1.PodSucceeded || phase == v1.PodFailed {
+			break
+		}
+		logger.Infof("waiting for pod %s on node %s to complete", name, nodeName)
+		time.Sleep(utils.RetryInterval * time.Second)
+	}
+	output, err := h.k8shelper.Clientset.CoreV1().Pods(namespace).GetLogs(name, &v1.PodLogOptions{}).Do().Raw()
+	if err != nil {
+		return "", fmt.Errorf("failed to get the logs of pod %s. %+v", name, err)
+	}
+	if phase != v1.PodSucceeded {
+		return "", fmt.Errorf("pod %s is %s instead of succeeded: %s", name, phase, string(output))
+	}
+	return string(output), nil
+}
+
+// renderNodeCommandPod returns a privileged pod that runs the command once on the node with the /dev of the host
+func renderNodeCommandPod(namespace, nodeName, name, image string, command []string) string {
+	manifest := `apiVersion: v1
+kind: Pod
+metadata:
+  name: ` + name + `
+  namespace: ` + namespace + `
+spec:
+  nodeName: ` + nodeName + `
+  restartPolicy: Never
+  containers:
+  - name: command
+    image: ` + image + `
+    command:`
+	for _, arg := range command {
+		manifest += `
+    - ` + strconv.Quote(arg)
+	}
+	return manifest + `
+    securityContext:
+      privileged: true
+    volumeMounts:
+    - name: dev
+      mountPath: /dev
+  volumes:
+  - name: dev
+    hostPath:
+      path: /dev
+`
+}
+
+// removeDeviceFromCluster updates the cluster CR without the device in the storage config of the node
+func (h *CephInstaller) removeDeviceFromCluster(namespace, nodeName, device string) error {
+	cluster, err := h.k8shelper.RookClientset.CephV1().CephClusters(namespace).Get(namespace, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to get the cluster in namespace %s. %+v", namespace, err)
+	}
+
+	found := false
+	for i, node := range cluster.Spec.Storage.Nodes {
+		if node.Name != nodeName {
+			continue
+		}
+		for j, d := range node.Devices {
+			if d.Name == device {
+				cluster.Spec.Storage.Nodes[i].Devices = append(node.Devices[:j], node.Devices[j+1:]...)
+				found = true
+				break
+			}
+		}
+	}
+	if !found {
+		return fmt.Errorf("device %s of node %s is not in the storage config of the cluster", device, nodeName)
+	}
+
+	if _, err := h.k8shelper.RookClientset.CephV1().CephClusters(namespace).Update(cluster); err != nil {
+		return fmt.Errorf("failed to update the cluster in namespace %s. %+v", namespace, err)
+	}
+	return nil
+}
+
+// removeNodeFromCluster updates the cluster CR without the node in the storage nodes
+func (h *CephInstaller) removeNodeFromCluster(namespace, nodeName string) error {
+	cluster, err := h.k8shelper.RookClientset.CephV1().CephClusters(namespace).Get(namespace, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to get the cluster in namespace %s. %+v", namespace, err)
+	}
+	if cluster.Spec.Storage.UseAllNodes {
+		return fmt.Errorf("node %s cannot be removed from the cluster in namespace %s since it uses all nodes", nodeName, namespace)
+	}
+
+	var found bool
+	cluster.Spec.Storage.Nodes, found = withoutNode(cluster.Spec.Storage.Nodes, nodeName)
+	if !found {
+		return fmt.Errorf("node %s is not in the storage config of the cluster", nodeName)
+	}
+
+	if _, err := h.k8shelper.RookClientset.CephV1().CephClusters(namespace).Update(cluster); err != nil {
+		return fmt.Errorf("failed to update the cluster in namespace %s. %+v", namespace, err)
+	}
+	return nil
+}
+
+// withoutNode returns the storage nodes without the node with the name, and whether the node was found
+func withoutNode(nodes []rookalpha.Node, name string) ([]rookalpha.Node, bool) {
+	var result []rookalpha.Node
+	found := false
+	for _, node := range nodes {
+		if node.Name == name {
+			found = true
+			continue
+		}
+		result = append(result, node)
+	}
+	return result, found
+}
+
+// osdIDsOnNode returns the ids of the osds whose deployments run on the node
+func (h *CephInstaller) osdIDsOnNode(namespace, nodeName string) ([]int, error) {
+	deployments, err := h.k8shelper.Clientset.ExtensionsV1beta1().Deployments(namespace).List(metav1.ListOptions{LabelSelector: "app=rook-ceph-osd"})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the osd deployments. %+v", err)
+	}
+	var ids []int
+	for _, d := range deployments.Items {
+		if d.Spec.Template.Spec.NodeSelector[apis.LabelHostname] != nodeName {
+			continue
+		}
+		id, err := strconv.Atoi(d.Labels[osdIDLabel])
+		if err != nil {
+			continue
+		}
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	return ids, nil
+}
+
+// waitForOSDRemoved waits until the osd is no longer found in the osd tree
+func (h *CephInstaller) waitForOSDRemoved(namespace string, id int) error {
+	start := time.Now()
+	for {
+		output, err := h.execCephCommand(namespace, "osd", "tree")
+		if err == nil {
+			var found bool
+			found, err = osdInTree(output, id)
+			if err == nil && !found {
+				logger.Infof("osd.%d was removed", id)
+				return nil
+			}
+		}
+		if time.Since(start) > osdRemovalTimeout {
+			return fmt.Errorf("gave up after %v waiting for osd.%d to be removed. %v", osdRemovalTimeout, id, err)
+		}
+		logger.Infof("waiting for osd.%d to be removed. %v", id, err)
+		time.Sleep(utils.RetryInterval * time.Second)
+	}
+}
+
+// osdInTree returns whether the osd is found in the json output of "ceph osd tree", including the stray osds
+func osdInTree(treeJSON []byte, id int) (bool, error) {
+	var tree struct {
+		Nodes []struct {
+			ID   int    `json:"id"`
+			Type string `json:"type"`
+		} `json:"nodes"`
+		Stray []struct {
+			ID int `json:"id"`
+		} `json:"stray"`
+	}
+	if err := json.Unmarshal(treeJSON, &tree); err != nil {
+		return false, fmt.Errorf("failed to unmarshal osd tree: %s. %+v", string(treeJSON), err)
+	}
+	for _, node := range tree.Nodes {
+		if node.Type == "osd" && node.ID == id {
+			return true, nil
+		}
+	}
+	for _, stray := range tree.Stray {
+		if stray.ID == id {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// VerifyCrushLocations confirms that the host bucket of each node is placed in the crush map under the buckets of its
+// expected location, keyed by hostname and then by bucket type such as {"node1": {"rack": "rack1"}}
+func (h *CephInstaller) VerifyCrushLocations(namespace string, locations map[string]map[string]string) error {
+	output, err := h.execCephCommand(namespace, "osd", "tree")
+	if err != nil {
+		return fmt.Errorf("failed to get the osd tree. %+v", err)
+	}
+	var failures []string
+	for host, location := range locations {
+		ancestors, err := crushAncestors(output, host)
+		if err != nil {
+			return err
+		}
+		for bucketType, name := range location {
+			if ancestors[bucketType] != name {
+				failures = append(failures, fmt.Sprintf("host %s is in %s %q instead of %q", host, bucketType, ancestors[bucketType], name))
+			}
+		}
+	}
+	if len(failures) > 0 {
+		sort.Strings(failures)
+		return fmt.Errorf("crush map does not match the node locations: %s", strings.Join(failures, "; "))
+	}
+	logger.Infof("crush map matches the locations of %d nodes", len(locations))
+	return nil
+}
+
+// crushAncestors returns the names of the buckets that contain the bucket in the json output of "ceph osd tree",
+// keyed by the bucket type
+func crushAncestors(treeJSON []byte, bucketName string) (map[string]string, error) {
+	var tree struct {
+		Nodes []struct {
+			ID       int    `json:"id"`
+			Name     string `json:"name"`
+			Type     string `json:"type"`
+			Children []int  `json:"children"`
+		} `json:"nodes"`
+	}
+	if err := json.Unmarshal(treeJSON, &tree); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal osd tree: %s. %+v", string(treeJSON), err)
+	}
+
+	parents := map[int]int{}
+	index := map[int]int{}
+	bucket, found := 0, false
+	for i, node := range tree.Nodes {
+		index[node.ID] = i
+		for _, child := range node.Children {
+			parents[child] = node.ID
+		}
+		if node.Name == bucketName {
+			bucket, found = node.ID, true
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("bucket %s not found in the osd tree", bucketName)
+	}
+
+	ancestors := map[string]string{}
+	for id, ok := parents[bucket]; ok; id, ok = parents[id] {
+		node := tree.Nodes[index[id]]
+		ancestors[node.Type] = node.Name
+	}
+	return ancestors, nil
+}
+
+// waitForCleanPGs waits until all the pgs are active+clean
+func (h *CephInstaller) waitForCleanPGs(namespace string) error {
+	context := h.k8shelper.MakeContext()
+	var err error
+	for i := 0; i < utils.RetryLoop; i++ {
+		if err = client.IsClusterClean(context, namespace); err == nil {
+			return nil
+		}
+		logger.Infof("waiting for the pgs to be active+clean. %v", err)
+		time.Sleep(utils.RetryInterval * time.Second)
+	}
+	return fmt.Errorf("gave up waiting for the pgs to be active+clean. %+v", err)
+}
+
+// VerifyClusterNetworkMTU confirms the interface of the cluster network address of each osd has the expected mtu
+// in the osd pod. The mtu observed for each mismatched osd is reported in the error.
+func (h *CephInstaller) VerifyClusterNetworkMTU(namespace string, expectedMTU int) error {
+	ids, err := h.GetOSDIDs(namespace)
+	if err != nil {
+		return err
+	}
+	var failures []string
+	for _, id := range ids {
+		iface, mtu, err := h.getOSDClusterNetworkMTU(namespace, id)
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("osd.%d: %+v", id, err))
+			continue
+		}
+		logger.Infof("osd.%d has mtu %d on cluster network interface %s", id, mtu, iface)
+		if mtu != expectedMTU {
+			failures = append(failures, fmt.Sprintf("osd.%d has mtu %d on %s instead of %d", id, mtu, iface, expectedMTU))
+		}
+	}
+	if len(failures) > 0 {
+		return fmt.Errorf("unexpected cluster network mtu: %s", strings.Join(failures, "; "))
+	}
+	return nil
+}
+
+// getOSDClusterNetworkMTU returns the interface and mtu of the cluster network (back) address of the osd as seen in
+// the osd pod
+func (h *CephInstaller) getOSDClusterNetworkMTU(namespace string, id int) (string, int, error) {
+	output, err := h.execCephCommand(namespace, "osd", "metadata", strconv.Itoa(id))
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to get the osd metadata. %+v", err)
+	}
+	var metadata struct {
+		BackAddr string `json:"back_addr"`
+	}
+	if err := json.Unmarshal(output, &metadata); err != nil {
+		return "", 0, fmt.Errorf("failed to unmarshal the osd metadata: %s. %+v", string(output), err)
+	}
+	match := osdAddrIPRegex.FindStringSubmatch(metadata.BackAddr)
+	if match == nil {
+		return "", 0, fmt.Errorf("no ip in cluster network address %q", metadata.BackAddr)
+	}
+
+	label := fmt.Sprintf("app=rook-ceph-osd,%s=%d", osdIDLabel, id)
+	pods, err := h.k8shelper.Clientset.CoreV1().Pods(namespace).List(metav1.ListOptions{LabelSelector: label})
+	if err != nil || len(pods.Items) == 0 {
+		return "", 0, fmt.Errorf("osd pod not found. %v", err)
+	}
+	podName := pods.Items[0].Name
+	addrs, err := h.k8shelper.Exec(namespace, podName, "ip", []string{"-o", "-4", "addr", "show"})
+	if err != nil {
+		return "", 0, err
+	}
+	iface := interfaceWithIP(addrs, match[1])
+	if iface == "" {
+		return "", 0, fmt.Errorf("no interface with ip %s in pod %s", match[1], podName)
+	}
+	mtu, err := h.k8shelper.Exec(namespace, podName, "cat", []string{"/sys/class/net/" + iface + "/mtu"})
+	if err != nil {
+		return "", 0, err
+	}
+	value, err := strconv.Atoi(strings.TrimSpace(mtu))
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid mtu %q of interface %s. %+v", mtu, iface, err)
+	}
+	return iface, value, nil
+}
+
+// interfaceWithIP returns the interface with the ip in the output of "ip -o -4 addr show", where each line is
+// formatted as "2: eth0    inet 10.0.0.5/24 brd 10.0.0.255 scope global eth0 ..."
+func interfaceWithIP(addrs, ip string) string {
+	for _, line := range strings.Split(addrs, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 4 || fields[2] != "inet" {
+			continue
+		}
+		if strings.Split(fields[3], "/")[0] == ip {
+			return fields[1]
+		}
+	}
+	return ""
+}
@@ -0,0 +1,115 @@
+/*
+Copyright 2019 The Rook Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package installer
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const cephClusterKind = "CephCluster"
+
+// VerifyOwnerReferences checks that the deployments, services, secrets and configmaps the operator created in the
+// cluster namespace have an owner reference to the CephCluster, so they are garbage collected when the cluster is
+// deleted. The resources created by the tests, such as the toolbox, are skipped. The resources without the reference
+// are returned in the error.
+func (h *CephInstaller) VerifyOwnerReferences(namespace string) error {
+	cluster, err := h.k8shelper.RookClientset.CephV1().CephClusters(namespace).Get(namespace, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to get cluster %s. %+v", namespace, err)
+	}
+
+	resources := map[string][]metav1.ObjectMeta{}
+	opts := metav1.ListOptions{}
+	deployments, err := h.k8shelper.Clientset.ExtensionsV1beta1().Deployments(namespace).List(opts)
+	if err != nil {
+		return fmt.Errorf("failed to list deployments. %+v", err)
+	}
+	for _, d := range deployments.Items {
+		resources["Deployment"] = append(resources["Deployment"], d.ObjectMeta)
+	}
+	services, err := h.k8shelper.Clientset.CoreV1().Services(namespace).List(opts)
+	if err != nil {
+		return fmt.Errorf("failed to list services. %+v", err)
+	}
+	for _, s := range services.Items {
+		resources["Service"] = append(resources["Service"], s.ObjectMeta)
+	}
+	secrets, err := h.k8shelper.Clientset.CoreV1().Secrets(namespace).List(opts)
+	if err != nil {
+		return fmt.Errorf("failed to list secrets. %+v", err)
+	}
+	for _, s := range secrets.Items {
+		resources["Secret"] = append(resources["Secret"], s.ObjectMeta)
+	}
+	configMaps, err := h.k8shelper.Clientset.CoreV1().ConfigMaps(namespace).List(opts)
+	if err != nil {
+		return fmt.Errorf("failed to list configmaps. %+v", err)
+	}
+	for _, c := range configMaps.Items {
+		resources["ConfigMap"] = append(resources["ConfigMap"], c.ObjectMeta)
+	}
+
+	missing := missingOwnerReferences(resources, cluster.UID, h.testCreatedResources())
+	if len(missing) > 0 {
+		return fmt.Errorf("resources in namespace %s without an owner reference to the cluster: %v", namespace, missing)
+	}
+	logger.Infof("the rook resources in namespace %s are owned by the cluster", namespace)
+	return nil
+}
+
+// testCreatedResources returns the kind/name of the resources the tests create in the cluster namespace
+func (h *CephInstaller) testCreatedResources() map[string]bool {
+	return map[string]bool{
+		"Deployment/rook-ceph-tools":              true,
+		"Service/" + externalDashboardServiceName: true,
+		"ConfigMap/rook-config-override":          true,
+	}
+}
+
+// missingOwnerReferences returns the kind/name of the rook resources that are not owned by the cluster with the uid.
+// Only the resources named after rook are considered, which excludes the resources k8s creates in the namespace such
+// as the service account tokens.
+func missingOwnerReferences(resources map[string][]metav1.ObjectMeta, clusterUID types.UID, skip map[string]bool) []string {
+	var missing []string
+	for kind, objects := range resources {
+		for _, object := range objects {
+			name := kind + "/" + object.Name
+			if !strings.HasPrefix(object.Name, "rook-") || skip[name] {
+				continue
+			}
+			if !ownedByCluster(object, clusterUID) {
+				missing = append(missing, name)
+			}
+		}
+	}
+	sort.Strings(missing)
+	return missing
+}
+
+func ownedByCluster(object metav1.ObjectMeta, clusterUID types.UID) bool {
+	for _, owner := range object.OwnerReferences {
+		if owner.Kind == cephClusterKind && owner.UID == clusterUID {
+			return true
+		}
+	}
+	return false
+}
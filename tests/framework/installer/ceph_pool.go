@@ -0,0 +1,274 @@
+/*
+Copyright 2019 The Rook Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package installer
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rook/rook/pkg/daemon/ceph/client"
+	"github.com/rook/rook/tests/framework/utils"
+)
+
+const (
+	quotaTestFile       = "/tmp/quota-test"
+	quotaTestObjectSize = 1024 * 1024
+	// the seconds to wait for a write before treating it as blocked by the quota
+	quotaWriteTimeout = "30"
+)
+
+// CreateBlockPools applies all the pools in a single manifest, then waits for each pool to be created in ceph with
+// the requested replication. The error lists every pool that failed.
+func (h *CephInstaller) CreateBlockPools(namespace string, pools []PoolSpec) error {
+	if len(pools) == 0 {
+		return nil
+	}
+
+	logger.Infof("creating %d pools in namespace %s", len(pools), namespace)
+	if _, err := h.k8shelper.ResourceOperation("apply", h.Manifests.GetBlockPools(namespace, pools)); err != nil {
+		return fmt.Errorf("failed to apply the pools. %+v", err)
+	}
+
+	var failures []string
+	for _, pool := range pools {
+		if err := h.waitForPool(namespace, pool); err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %+v", pool.Name, err))
+			continue
+		}
+		if pool.CompressionMode != "" || pool.CompressionAlgorithm != "" {
+			if err := h.VerifyPoolCompression(namespace, pool); err != nil {
+				failures = append(failures, fmt.Sprintf("%s: %+v", pool.Name, err))
+			}
+		}
+		if pool.MirroringMode != "" {
+			if err := h.VerifyPoolMirroring(namespace, pool.Name, pool.MirroringMode); err != nil {
+				failures = append(failures, fmt.Sprintf("%s: %+v", pool.Name, err))
+			}
+		}
+		if pool.hasQuota() {
+			if err := h.VerifyPoolQuota(namespace, pool); err != nil {
+				failures = append(failures, fmt.Sprintf("%s: %+v", pool.Name, err))
+			}
+		}
+	}
+	if len(failures) > 0 {
+		return fmt.Errorf("%d of %d pools are not ready: %s", len(failures), len(pools), strings.Join(failures, "; "))
+	}
+	return nil
+}
+
+// CreateBlockPoolsConcurrently applies each pool with a separate request at the same time, then waits in parallel for
+// every pool to be created in ceph with the requested replication. Pools that were lost or never reconciled by the
+// operator are listed in the error.
+func (h *CephInstaller) CreateBlockPoolsConcurrently(namespace string, pools []PoolSpec) error {
+	logger.Infof("creating %d pools concurrently in namespace %s", len(pools), namespace)
+	start := time.Now()
+	var lock sync.Mutex
+	var failures []string
+	addFailure := func(pool PoolSpec, err error) {
+		lock.Lock()
+		defer lock.Unlock()
+		failures = append(failures, fmt.Sprintf("%s: %+v", pool.Name, err))
+	}
+
+	var wg sync.WaitGroup
+	for _, pool := range pools {
+		wg.Add(1)
+		go func(pool PoolSpec) {
+			defer wg.Done()
+			manifest := h.Manifests.GetBlockPools(namespace, []PoolSpec{pool})
+			if _, err := h.k8shelper.ResourceOperation("apply", manifest); err != nil {
+				addFailure(pool, fmt.Errorf("failed to apply the pool. %+v", err))
+				return
+			}
+			if err := h.waitForPool(namespace, pool); err != nil {
+				addFailure(pool, err)
+			}
+		}(pool)
+	}
+	wg.Wait()
+
+	if len(failures) > 0 {
+		sort.Strings(failures)
+		return fmt.Errorf("%d of %d concurrently created pools are not ready: %s", len(failures), len(pools), strings.Join(failures, "; "))
+	}
+	logger.Infof("all %d concurrently created pools are ready after %v", len(pools), time.Since(start))
+	return nil
+}
+
+// waitForPool waits until the pool exists in ceph with the expected replica size
+func (h *CephInstaller) waitForPool(namespace string, pool PoolSpec) error {
+	context := h.k8shelper.MakeContext()
+	var err error
+	for i := 0; i < utils.RetryLoop; i++ {
+		var details client.CephStoragePoolDetails
+		details, err = client.GetPoolDetails(context, namespace, pool.Name)
+		if err == nil {
+			if int(details.Size) == pool.Replicas {
+				logger.Infof("pool %s is ready with %d replicas", pool.Name, details.Size)
+				return nil
+			}
+			err = fmt.Errorf("pool has size %d instead of %d", details.Size, pool.Replicas)
+		}
+		logger.Infof("waiting for pool %s. %v", pool.Name, err)
+		time.Sleep(utils.RetryInterval * time.Second)
+	}
+	return fmt.Errorf("gave up waiting for the pool. %+v", err)
+}
+
+// VerifyPoolCompression confirms the compression mode and algorithm of the pool in ceph match the pool spec. Settings
+// that are empty in the spec are not checked.
+func (h *CephInstaller) VerifyPoolCompression(namespace string, pool PoolSpec) error {
+	for property, expected := range pool.compressionParameters() {
+		actual, err := h.getPoolProperty(namespace, pool.Name, property)
+		if err != nil {
+			return err
+		}
+		if actual != expected {
+			return fmt.Errorf("pool %s has %s %q instead of %q", pool.Name, property, actual, expected)
+		}
+	}
+	logger.Infof("pool %s has the expected compression settings", pool.Name)
+	return nil
+}
+
+// getPoolProperty returns the value of a pool property with "ceph osd pool get"
+func (h *CephInstaller) getPoolProperty(namespace, poolName, property string) (string, error) {
+	output, err := h.execCephCommand(namespace, "osd", "pool", "get", poolName, property)
+	if err != nil {
+		return "", fmt.Errorf("failed to get %s of pool %s. %+v", property, poolName, err)
+	}
+	var response map[string]interface{}
+	if err := json.Unmarshal(output, &response); err != nil {
+		return "", fmt.Errorf("failed to unmarshal the %s of pool %s: %s. %+v", property, poolName, string(output), err)
+	}
+	value, ok := response[property]
+	if !ok {
+		return "", fmt.Errorf("%s not found for pool %s: %s", property, poolName, string(output))
+	}
+	return fmt.Sprintf("%v", value), nil
+}
+
+// VerifyPoolMirroring confirms with "rbd mirror pool info" that mirroring is enabled on the pool in the given mode
+// (image or pool), and that "rbd mirror pool status" reports the mirroring status of the pool
+func (h *CephInstaller) VerifyPoolMirroring(namespace, poolName, mode string) error {
+	output, err := h.execRBDCommand(namespace, "mirror", "pool", "info", poolName)
+	if err != nil {
+		return fmt.Errorf("failed to get the mirroring info of pool %s. %+v", poolName, err)
+	}
+	var info struct {
+		Mode string `json:"mode"`
+	}
+	if err := json.Unmarshal(output, &info); err != nil {
+		return fmt.Errorf("failed to unmarshal the mirroring info of pool %s: %s. %+v", poolName, string(output), err)
+	}
+	if info.Mode != mode {
+		return fmt.Errorf("pool %s has mirroring mode %q instead of %q", poolName, info.Mode, mode)
+	}
+
+	if _, err := h.execRBDCommand(namespace, "mirror", "pool", "status", poolName); err != nil {
+		return fmt.Errorf("mirroring is not active on pool %s. %+v", poolName, err)
+	}
+	logger.Infof("mirroring is enabled on pool %s in mode %s", poolName, mode)
+	return nil
+}
+
+// PoolQuota is the response of "ceph osd pool get-quota"
+type PoolQuota struct {
+	MaxBytes   uint64 `json:"quota_max_bytes"`
+	MaxObjects uint64 `json:"quota_max_objects"`
+}
+
+// GetPoolQuota returns the quotas of the pool
+func (h *CephInstaller) GetPoolQuota(namespace, poolName string) (*PoolQuota, error) {
+	output, err := h.execCephCommand(namespace, "osd", "pool", "get-quota", poolName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the quota of pool %s. %+v", poolName, err)
+	}
+	var quota PoolQuota
+	if err := json.Unmarshal(output, &quota); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal the quota of pool %s: %s. %+v", poolName, string(output), err)
+	}
+	return &quota, nil
+}
+
+// VerifyPoolQuota waits for the quotas of the pool in ceph to match the pool spec
+func (h *CephInstaller) VerifyPoolQuota(namespace string, pool PoolSpec) error {
+	var quota *PoolQuota
+	var err error
+	for i := 0; i < utils.RetryLoop; i++ {
+		quota, err = h.GetPoolQuota(namespace, pool.Name)
+		if err == nil {
+			if quota.MaxBytes == pool.MaxBytes && quota.MaxObjects == pool.MaxObjects {
+				logger.Infof("pool %s has the expected quota %+v", pool.Name, *quota)
+				return nil
+			}
+			err = fmt.Errorf("pool has quota %+v instead of max bytes %d and max objects %d", *quota, pool.MaxBytes, pool.MaxObjects)
+		}
+		logger.Infof("waiting for the quota of pool %s. %v", pool.Name, err)
+		time.Sleep(utils.RetryInterval * time.Second)
+	}
+	return fmt.Errorf("gave up waiting for the quota of pool %s. %+v", pool.Name, err)
+}
+
+// VerifyPoolQuotaEnforced writes objects of quotaTestObjectSize to the pool from the toolbox until ceph rejects a
+// write, and fails if the writes still succeed after the quota of the pool spec is exceeded. Ceph flags the pool as
+// full asynchronously, so a few writes past the quota are allowed. Writes that block on the full pool count as
+// rejected. The objects are left in the pool.
+func (h *CephInstaller) VerifyPoolQuotaEnforced(namespace string, pool PoolSpec) error {
+	if !pool.hasQuota() {
+		return fmt.Errorf("pool %s has no quota", pool.Name)
+	}
+	if _, err := h.k8shelper.Exec(namespace, "rook-ceph-tools", "dd", []string{"if=/dev/zero", "of=" + quotaTestFile, "bs=" + strconv.Itoa(quotaTestObjectSize), "count=1"}); err != nil {
+		return fmt.Errorf("failed to create the test object. %+v", err)
+	}
+
+	writes := quotaWrites(pool)
+	for i := 0; i < writes+utils.RetryLoop; i++ {
+		object := fmt.Sprintf("quota-test-%d", i)
+		args := []string{quotaWriteTimeout, "rados", "-p", pool.Name, "put", object, quotaTestFile}
+		if _, err := h.k8shelper.Exec(namespace, "rook-ceph-tools", "timeout", args); err != nil {
+			if i < writes {
+				logger.Warningf("write %d to pool %s was rejected before the quota was exceeded. %+v", i, pool.Name, err)
+			}
+			logger.Infof("the quota of pool %s rejected write %d. %+v", pool.Name, i, err)
+			return nil
+		}
+		if i >= writes {
+			// give ceph time to flag the pool as full
+			time.Sleep(utils.RetryInterval * time.Second)
+		}
+	}
+	return fmt.Errorf("pool %s accepted %d writes of %d bytes with quota max bytes %d and max objects %d", pool.Name, writes+utils.RetryLoop, quotaTestObjectSize, pool.MaxBytes, pool.MaxObjects)
+}
+
+// quotaWrites returns the number of writes of quotaTestObjectSize that exceed the quota of the pool
+func quotaWrites(pool PoolSpec) int {
+	writes := 0
+	if pool.MaxBytes > 0 {
+		writes = int(pool.MaxBytes/quotaTestObjectSize) + 1
+	}
+	if pool.MaxObjects > 0 && (writes == 0 || int(pool.MaxObjects)+1 < writes) {
+		writes = int(pool.MaxObjects) + 1
+	}
+	return writes
+}
@@ -0,0 +1,227 @@
+/*
+Copyright 2019 The Rook Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package installer
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rook/rook/tests/framework/utils"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// the port of the metrics of the controllers of the operator
+	operatorMetricsPort = 8080
+	poolControllerName  = "ceph-block-pool-controller"
+
+	reconcileTimeBucketMetric = "controller_runtime_reconcile_time_seconds_bucket"
+	reconcileErrorsMetric     = "controller_runtime_reconcile_errors_total"
+)
+
+// matches the labels of a sample, such as `controller="ceph-block-pool-controller",le="0.005"`
+var metricLabelRegex = regexp.MustCompile(`(\w+)="([^"]*)"`)
+
+// ReconcileStats are the reconciles of a controller of the operator between two scrapes of its metrics. The
+// percentiles are the upper bounds of the histogram buckets of the reconcile time.
+type ReconcileStats struct {
+	Reconciles int
+	Errors     int
+	P50        time.Duration
+	P90        time.Duration
+	P99        time.Duration
+}
+
+// MeasureReconcileUnderChurn creates, updates and deletes pools in quick succession for the number of operations, and
+// returns the latency percentiles and errors of the reconciles of the pool controller during the churn, from the
+// metrics of the operator. The operator must serve the controller metrics. The pools left by the churn are deleted.
+func (h *CephInstaller) MeasureReconcileUnderChurn(namespace string, churnOps int) (ReconcileStats, error) {
+	systemNamespace := SystemNamespace(namespace)
+	before, err := h.scrapeOperatorMetrics(namespace, systemNamespace)
+	if err != nil {
+		return ReconcileStats{}, err
+	}
+
+	var pools []string
+	defer func() {
+		for _, pool := range pools {
+			h.k8shelper.DeleteResource("-n", namespace, "CephBlockPool", pool)
+		}
+	}()
+	start := time.Now()
+	for i := 0; i < churnOps; i++ {
+		name := fmt.Sprintf("churn-pool-%d", i/3)
+		switch i % 3 {
+		case 0:
+			pools = append(pools, name)
+			_, err = h.k8shelper.ResourceOperation("apply", h.Manifests.GetBlockPools(namespace, []PoolSpec{{Name: name, Replicas: 1}}))
+		case 1:
+			_, err = h.k8shelper.ResourceOperation("apply", h.Manifests.GetBlockPools(namespace, []PoolSpec{{Name: name, Replicas: 1, CompressionMode: "passive"}}))
+		case 2:
+			_, err = h.k8shelper.DeleteResource("-n", namespace, "CephBlockPool", name)
+			pools = pools[:len(pools)-1]
+		}
+		if err != nil {
+			return ReconcileStats{}, fmt.Errorf("churn operation %d on pool %s failed. %+v", i, name, err)
+		}
+	}
+	logger.Infof("ran %d churn operations on the pools in %v", churnOps, time.Since(start))
+
+	after, err := h.waitForReconcilesSettled(namespace, systemNamespace)
+	if err != nil {
+		return ReconcileStats{}, err
+	}
+	stats := reconcileStats(before, after, poolControllerName)
+	logger.Infof("reconciles of the %s under churn: %+v", poolControllerName, stats)
+	return stats, nil
+}
+
+// waitForReconcilesSettled scrapes the operator metrics until the number of reconciles of the pool controller stops
+// changing between two scrapes, and returns the last scrape
+func (h *CephInstaller) waitForReconcilesSettled(namespace, systemNamespace string) (map[string]float64, error) {
+	var previous map[string]float64
+	for i := 0; i < utils.RetryLoop; i++ {
+		time.Sleep(utils.RetryInterval * time.Second)
+		metrics, err := h.scrapeOperatorMetrics(namespace, systemNamespace)
+		if err != nil {
+			return nil, err
+		}
+		if previous != nil && reconcileStats(previous, metrics, poolControllerName).Reconciles == 0 {
+			return metrics, nil
+		}
+		previous = metrics
+	}
+	logger.Warningf("the reconciles of the %s did not settle", poolControllerName)
+	return previous, nil
+}
+
+// scrapeOperatorMetrics fetches the metrics of the operator pod from the toolbox of the cluster
+func (h *CephInstaller) scrapeOperatorMetrics(namespace, systemNamespace string) (map[string]float64, error) {
+	pods, err := h.k8shelper.Clientset.CoreV1().Pods(systemNamespace).List(metav1.ListOptions{LabelSelector: "app=" + operatorAppName})
+	if err != nil || len(pods.Items) == 0 {
+		return nil, fmt.Errorf("operator pod not found in namespace %s. %v", systemNamespace, err)
+	}
+	url := fmt.Sprintf("http://%s:%d/metrics", pods.Items[0].Status.PodIP, operatorMetricsPort)
+	output, err := h.k8shelper.Exec(namespace, "rook-ceph-tools", "curl", []string{"-s", "-f", url})
+	if err != nil {
+		return nil, fmt.Errorf("failed to scrape the operator metrics at %s. %+v", url, err)
+	}
+	return parsePrometheusMetrics(output)
+}
+
+// parsePrometheusMetrics parses the samples of the prometheus text format. The samples are keyed by the metric name
+// including the labels, such as `ceph_osd_op_r{ceph_daemon="osd.0"}`. An optional timestamp after the value is ignored.
+func parsePrometheusMetrics(text string) (map[string]float64, error) {
+	metrics := map[string]float64{}
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		// label values may contain spaces, so the name ends after the labels if there are any
+		nameEnd := strings.IndexAny(line, " {")
+		if nameEnd >= 0 && line[nameEnd] == '{' {
+			nameEnd = strings.LastIndex(line, "}") + 1
+		}
+		if nameEnd <= 0 {
+			return nil, fmt.Errorf("invalid sample %q", line)
+		}
+		fields := strings.Fields(line[nameEnd:])
+		if len(fields) == 0 || len(fields) > 2 {
+			return nil, fmt.Errorf("invalid sample %q", line)
+		}
+		value, err := strconv.ParseFloat(fields[0], 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value in sample %q. %+v", line, err)
+		}
+		metrics[line[:nameEnd]] = value
+	}
+	return metrics, nil
+}
+
+// reconcileStats returns the reconciles of the controller between the two scrapes of the metrics
+func reconcileStats(before, after map[string]float64, controller string) ReconcileStats {
+	// the cumulative count of the reconciles of each bucket, keyed by the upper bound of the bucket
+	buckets := map[float64]float64{}
+	var errors float64
+	for key, value := range after {
+		labels := metricLabels(key)
+		if labels["controller"] != controller {
+			continue
+		}
+		delta := value - before[key]
+		switch {
+		case strings.HasPrefix(key, reconcileTimeBucketMetric+"{"):
+			le, err := strconv.ParseFloat(labels["le"], 64)
+			if err == nil {
+				buckets[le] = delta
+			}
+		case strings.HasPrefix(key, reconcileErrorsMetric+"{"):
+			errors += delta
+		}
+	}
+
+	bounds := make([]float64, 0, len(buckets))
+	for le := range buckets {
+		bounds = append(bounds, le)
+	}
+	sort.Float64s(bounds)
+	total := 0.0
+	if len(bounds) > 0 {
+		total = buckets[bounds[len(bounds)-1]]
+	}
+	quantile := func(q float64) time.Duration {
+		if total == 0 {
+			return 0
+		}
+		finite := 0.0
+		for _, le := range bounds {
+			if !math.IsInf(le, 1) {
+				finite = le
+			}
+			if buckets[le] >= q*total {
+				break
+			}
+		}
+		return time.Duration(finite * float64(time.Second))
+	}
+	return ReconcileStats{
+		Reconciles: int(total),
+		Errors:     int(errors),
+		P50:        quantile(0.5),
+		P90:        quantile(0.9),
+		P99:        quantile(0.99),
+	}
+}
+
+// metricLabels returns the labels of the sample key of parsePrometheusMetrics
+func metricLabels(key string) map[string]string {
+	labels := map[string]string{}
+	start := strings.Index(key, "{")
+	if start < 0 {
+		return labels
+	}
+	for _, match := range metricLabelRegex.FindAllStringSubmatch(key[start:], -1) {
+		labels[match[1]] = match[2]
+	}
+	return labels
+}
@@ -0,0 +1,61 @@
+/*
+Copyright 2019 The Rook Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package installer
+
+import (
+	"fmt"
+
+	cephv1 "github.com/rook/rook/pkg/apis/ceph.rook.io/v1"
+	"github.com/rook/rook/pkg/daemon/ceph/client"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// CephClusterStatus is the state of the cluster CR with the health of the live cluster
+type CephClusterStatus struct {
+	// State and Message are reported by the operator in the status of the cluster CR
+	State   cephv1.ClusterState
+	Message string
+	// Health is the ceph health, such as HEALTH_OK
+	Health string
+	// MonCount is the number of mons in the mon map and OSDCount the number of osds in the osd map
+	MonCount int
+	OSDCount int
+}
+
+// GetClusterStatus returns the state of the cluster CR, and the health and daemon counts of "ceph status" run in the
+// toolbox, so the tests can assert the state of the CR matches the live cluster
+func (h *CephInstaller) GetClusterStatus(namespace string) (CephClusterStatus, error) {
+	cluster, err := h.k8shelper.RookClientset.CephV1().CephClusters(namespace).Get(namespace, metav1.GetOptions{})
+	if err != nil {
+		return CephClusterStatus{}, fmt.Errorf("failed to get cluster %s. %+v", namespace, err)
+	}
+	status, err := client.Status(h.k8shelper.MakeContext(), namespace)
+	if err != nil {
+		return CephClusterStatus{}, fmt.Errorf("failed to get the ceph status of cluster %s. %+v", namespace, err)
+	}
+	return newCephClusterStatus(cluster.Status, status), nil
+}
+
+func newCephClusterStatus(cluster cephv1.ClusterStatus, ceph client.CephStatus) CephClusterStatus {
+	return CephClusterStatus{
+		State:    cluster.State,
+		Message:  cluster.Message,
+		Health:   ceph.Health.Status,
+		MonCount: len(ceph.MonMap.Mons),
+		OSDCount: ceph.OsdMap.OsdMap.NumOsd,
+	}
+}
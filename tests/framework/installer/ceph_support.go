@@ -0,0 +1,184 @@
+/*
+Copyright 2019 The Rook Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package installer
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+var (
+	// the ceph commands whose output is included in the support bundle, written to ceph/<name>.json
+	supportCephCommands = map[string][]string{
+		"status":        {"status"},
+		"health-detail": {"health", "detail"},
+		"versions":      {"versions"},
+		"osd-tree":      {"osd", "tree"},
+		"osd-df":        {"osd", "df"},
+		"osd-dump":      {"osd", "dump"},
+		"mon-dump":      {"mon", "dump"},
+		"pg-stuck":      {"pg", "dump_stuck"},
+		"config-dump":   {"config", "dump"},
+	}
+	// the rook CRs dumped from the cluster namespace
+	supportCRKinds = []string{"cephclusters", "cephblockpools", "cephfilesystems", "cephobjectstores", "cephobjectstoreusers", "cephnfses"}
+)
+
+// CollectSupportBundle writes a gzipped tarball to outPath with the pod logs, the events of the cluster and system
+// namespaces, ceph diagnostics, the rook CRDs and CRs, and the operator deployment. Parts that fail to be collected
+// are listed in errors.txt of the bundle instead of failing the collection. An error is only returned if the bundle
+// cannot be written.
+func (h *CephInstaller) CollectSupportBundle(namespace, systemNamespace, outPath string) error {
+	dir, err := ioutil.TempDir("", "rook-support-bundle")
+	if err != nil {
+		return fmt.Errorf("failed to create the bundle dir. %+v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	var failures []string
+	record := func(err error) {
+		if err != nil {
+			logger.Warningf("support bundle: %+v", err)
+			failures = append(failures, err.Error())
+		}
+	}
+	record(h.collectSupportLogs(namespace, systemNamespace, path.Join(dir, "logs")))
+	for _, ns := range []string{namespace, systemNamespace} {
+		record(h.writeSupportResource(path.Join(dir, "events", ns+".yaml"), "events", "-n", ns, "-o", "yaml"))
+	}
+	for name, args := range supportCephCommands {
+		output, err := h.execCephCommand(namespace, args...)
+		if err != nil {
+			record(fmt.Errorf("ceph %s: %+v", strings.Join(args, " "), err))
+			continue
+		}
+		record(writeSupportFile(path.Join(dir, "ceph", name+".json"), output))
+	}
+	record(h.DumpInstalledCRDs(path.Join(dir, "crds")))
+	for _, kind := range supportCRKinds {
+		record(h.writeSupportResource(path.Join(dir, "crs", kind+".yaml"), kind, "-n", namespace, "-o", "yaml"))
+	}
+	record(h.writeSupportResource(path.Join(dir, "operator", "deployment.yaml"), "deployment", "rook-ceph-operator", "-n", systemNamespace, "-o", "yaml"))
+	record(h.writeSupportResource(path.Join(dir, "operator", "configmaps.yaml"), "configmaps", "-n", systemNamespace, "-o", "yaml"))
+
+	if len(failures) > 0 {
+		if err := writeSupportFile(path.Join(dir, "errors.txt"), []byte(strings.Join(failures, "\n")+"\n")); err != nil {
+			return err
+		}
+	}
+	if err := writeTarGz(dir, outPath); err != nil {
+		return fmt.Errorf("failed to write the support bundle %s. %+v", outPath, err)
+	}
+	logger.Infof("wrote the support bundle of cluster %s to %s with %d errors", namespace, outPath, len(failures))
+	return nil
+}
+
+// collectSupportLogs gathers the rook and csi logs with a unique test name and moves the log files to the dir
+func (h *CephInstaller) collectSupportLogs(namespace, systemNamespace, dir string) error {
+	testName := fmt.Sprintf("support-bundle-%d", time.Now().UnixNano())
+	h.GatherAllRookLogs(namespace, systemNamespace, testName)
+	h.GatherCSILogs(namespace, testName)
+
+	// the gather helpers write the logs to _output/tests of the working dir
+	cwd, err := os.Getwd()
+	if err != nil {
+		return fmt.Errorf("failed to get the working dir. %+v", err)
+	}
+	logs, err := filepath.Glob(path.Join(cwd, "_output/tests", testName+"_*"))
+	if err != nil {
+		return fmt.Errorf("failed to find the logs. %+v", err)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create dir %s. %+v", dir, err)
+	}
+	for _, log := range logs {
+		name := strings.TrimPrefix(path.Base(log), testName+"_")
+		if err := os.Rename(log, path.Join(dir, name)); err != nil {
+			return fmt.Errorf("failed to move log %s. %+v", log, err)
+		}
+	}
+	return nil
+}
+
+func (h *CephInstaller) writeSupportResource(file string, args ...string) error {
+	output, err := h.k8shelper.GetResource(args...)
+	if err != nil {
+		return fmt.Errorf("kubectl get %s: %+v", strings.Join(args, " "), err)
+	}
+	return writeSupportFile(file, []byte(output))
+}
+
+func writeSupportFile(file string, data []byte) error {
+	if err := os.MkdirAll(path.Dir(file), 0755); err != nil {
+		return fmt.Errorf("failed to create dir of %s. %+v", file, err)
+	}
+	if err := ioutil.WriteFile(file, data, 0644); err != nil {
+		return fmt.Errorf("failed to write %s. %+v", file, err)
+	}
+	return nil
+}
+
+// writeTarGz archives the files of the dir into a gzipped tarball. The paths in the archive are relative to the dir.
+func writeTarGz(dir, outPath string) error {
+	out, err := os.Create(outPath)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	gz := gzip.NewWriter(out)
+	tw := tar.NewWriter(gz)
+
+	err = filepath.Walk(dir, func(file string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		name, err := filepath.Rel(dir, file)
+		if err != nil {
+			return err
+		}
+		header, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(name)
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		f, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(tw, f)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
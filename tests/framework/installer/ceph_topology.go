@@ -0,0 +1,110 @@
+/*
+Copyright 2019 The Rook Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package installer
+
+import (
+	"fmt"
+	"strings"
+
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/kubernetes/pkg/kubelet/apis"
+)
+
+// the prefix of the rook topology labels, which are named after the crush bucket type such as topology.rook.io/rack
+const rookTopologyLabelPrefix = "topology.rook.io/"
+
+// crushTypesOfLabels are the crush bucket types of the well known k8s topology labels
+var crushTypesOfLabels = map[string]string{
+	"topology.kubernetes.io/zone":              "zone",
+	"topology.kubernetes.io/region":            "region",
+	"failure-domain.beta.kubernetes.io/zone":   "zone",
+	"failure-domain.beta.kubernetes.io/region": "region",
+}
+
+// storageNodeLocations returns the crush locations of the storage nodes, with the bucket of the failure domain label
+// of each node added to the locations of the installer. The operator does not read the topology labels of the nodes
+// itself, so the label is rendered in the location of the storage nodes of the cluster CR.
+func (h *CephInstaller) storageNodeLocations() (map[string]map[string]string, error) {
+	if h.FailureDomainLabel == "" {
+		return h.Cluster.NodeLocations, nil
+	}
+	nodes, err := h.k8shelper.Clientset.CoreV1().Nodes().List(metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get k8s nodes. %+v", err)
+	}
+	return topologyLocations(nodes.Items, h.FailureDomainLabel, h.Cluster.NodeLocations)
+}
+
+// VerifyCrushTopology confirms that the host bucket of each node with the failure domain label of the installer is
+// placed in the crush map under the bucket named after the value of the label, such as the zone of the node
+func (h *CephInstaller) VerifyCrushTopology(namespace string) error {
+	if h.FailureDomainLabel == "" {
+		return fmt.Errorf("no failure domain label is set")
+	}
+	nodes, err := h.k8shelper.Clientset.CoreV1().Nodes().List(metav1.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to get k8s nodes. %+v", err)
+	}
+	locations, err := topologyLocations(nodes.Items, h.FailureDomainLabel, nil)
+	if err != nil {
+		return err
+	}
+	if len(locations) == 0 {
+		return fmt.Errorf("no node has the failure domain label %s", h.FailureDomainLabel)
+	}
+	return h.VerifyCrushLocations(namespace, locations)
+}
+
+// topologyLocations adds the bucket of the failure domain label to a copy of the locations of each node that has
+// the label, keyed by hostname
+func topologyLocations(nodes []v1.Node, label string, locations map[string]map[string]string) (map[string]map[string]string, error) {
+	bucketType, err := crushTypeOfLabel(label)
+	if err != nil {
+		return nil, err
+	}
+	result := map[string]map[string]string{}
+	for host, location := range locations {
+		result[host] = map[string]string{}
+		for k, v := range location {
+			result[host][k] = v
+		}
+	}
+	for _, node := range nodes {
+		value, ok := node.Labels[label]
+		if !ok {
+			continue
+		}
+		host := node.Labels[apis.LabelHostname]
+		if result[host] == nil {
+			result[host] = map[string]string{}
+		}
+		result[host][bucketType] = value
+	}
+	return result, nil
+}
+
+// crushTypeOfLabel returns the crush bucket type of a topology label
+func crushTypeOfLabel(label string) (string, error) {
+	if bucketType, ok := crushTypesOfLabels[label]; ok {
+		return bucketType, nil
+	}
+	if strings.HasPrefix(label, rookTopologyLabelPrefix) && len(label) > len(rookTopologyLabelPrefix) {
+		return strings.TrimPrefix(label, rookTopologyLabelPrefix), nil
+	}
+	return "", fmt.Errorf("unknown crush bucket type of topology label %s", label)
+}
@@ -0,0 +1,43 @@
+/*
+Copyright 2019 The Rook Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package installer
+
+import (
+	"fmt"
+
+	"github.com/rook/rook/pkg/operator/k8sutil"
+)
+
+// UpgradeCephVersion updates the ceph image of the cluster and waits for the mons and osds to run the new image
+func (h *CephInstaller) UpgradeCephVersion(namespace, image string) error {
+	patch := fmt.Sprintf(`{"spec":{"cephVersion":{"image":%q}}}`, image)
+	if _, err := h.k8shelper.Kubectl("-n", namespace, "patch", "cephcluster", namespace, "--type=merge", "-p", patch); err != nil {
+		return fmt.Errorf("failed to update the ceph image of cluster %s to %s. %+v", namespace, image, err)
+	}
+
+	for _, daemon := range []string{"mon", "osd"} {
+		label := "app=rook-ceph-" + daemon
+		if err := k8sutil.WaitForDeploymentImage(h.k8shelper.Clientset, namespace, label, daemon, false, image); err != nil {
+			return fmt.Errorf("the %ss of cluster %s were not upgraded to %s. %+v", daemon, namespace, image, err)
+		}
+		if err := h.k8shelper.WaitForLabeledPodsToRun(label, namespace); err != nil {
+			return fmt.Errorf("the %ss of cluster %s are not running after the upgrade. %+v", daemon, namespace, err)
+		}
+	}
+	logger.Infof("upgraded cluster %s to %s", namespace, image)
+	return nil
+}
@@ -20,6 +20,9 @@ import (
 	"fmt"
 	"os"
 	"runtime"
+	"sort"
+	"strconv"
+	"strings"
 	"testing"
 
 	"github.com/coreos/pkg/capnslog"
@@ -83,6 +86,22 @@ func concatYaml(first, second string) string {
 ` + second
 }
 
+// renderStringMap renders the key/value pairs as a yaml map with the given indentation. The keys are sorted
+// so the rendered manifest is stable.
+func renderStringMap(values map[string]string, indent int) string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	result := ""
+	for _, key := range keys {
+		result += "\n" + strings.Repeat(" ", indent) + strconv.Quote(key) + ": " + strconv.Quote(values[key])
+	}
+	return result
+}
+
 // GatherCRDObjectDebuggingInfo gathers all the descriptions for pods, pvs and pvcs
 func GatherCRDObjectDebuggingInfo(k8shelper *utils.K8sHelper, namespace string) {
 	k8shelper.PrintPodDescribeForNamespace(namespace)
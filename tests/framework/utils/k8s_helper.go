@@ -1404,6 +1404,28 @@ func (k8sh *K8sHelper) IsRookInstalled(namespace string) bool {
 	return false
 }
 
+// GetPodLogsWithLabel returns the logs of all the pods with the given label, keyed by the pod name
+func (k8sh *K8sHelper) GetPodLogsWithLabel(label, namespace, containerName string) (map[string]string, error) {
+	logOpts := &v1.PodLogOptions{}
+	if containerName != "" {
+		logOpts.Container = containerName
+	}
+	pods, err := k8sh.Clientset.CoreV1().Pods(namespace).List(metav1.ListOptions{LabelSelector: label})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pods with label %s in namespace %s. %+v", label, namespace, err)
+	}
+
+	logs := map[string]string{}
+	for _, pod := range pods.Items {
+		rawData, err := k8sh.Clientset.CoreV1().Pods(namespace).GetLogs(pod.Name, logOpts).Do().Raw()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get logs for pod %s in namespace %s. %+v", pod.Name, namespace, err)
+		}
+		logs[pod.Name] = string(rawData)
+	}
+	return logs, nil
+}
+
 // GetRookLogs captures logs from specified rook pod and writes it to specified file
 func (k8sh *K8sHelper) GetRookLogs(podAppName string, hostType string, namespace string, testName string) {
 	k8sh.GetRookContainerLogs(podAppName, hostType, namespace, testName, "")
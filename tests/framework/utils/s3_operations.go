@@ -18,6 +18,9 @@ package utils
 
 import (
 	"bytes"
+	"context"
+	"net"
+	"net/http"
 	"strings"
 
 	"github.com/aws/aws-sdk-go/aws"
@@ -54,6 +57,34 @@ func CreateNewS3Helper(endpoint string, keyID string, keySecret string) *S3Helpe
 	return &S3Helper{c}
 }
 
+// CreateNewVirtualHostedS3Helper creates a s3 client that addresses the buckets with virtual-hosted-style requests to
+// <bucket>.<dnsName>. The connections are made to the endpoint regardless of the host, so the dns name does not
+// need to resolve.
+func CreateNewVirtualHostedS3Helper(endpoint, dnsName, keyID, keySecret string) (*S3Helper, error) {
+	_, port, err := net.SplitHostPort(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	dialer := &net.Dialer{}
+	httpClient := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return dialer.DialContext(ctx, network, endpoint)
+		},
+	}}
+
+	creds := credentials.NewStaticCredentials(keyID, keySecret, "")
+	awsConfig := aws.NewConfig().
+		WithRegion("us-east-1").
+		WithCredentials(creds).
+		WithEndpoint(net.JoinHostPort(dnsName, port)).
+		WithS3ForcePathStyle(false).
+		WithDisableSSL(true).
+		WithHTTPClient(httpClient).
+		WithMaxRetries(20)
+
+	return &S3Helper{s3.New(session.New(), awsConfig)}, nil
+}
+
 // CreateBucket function creates  bucket using s3 client
 func (h *S3Helper) CreateBucket(name string) (bool, error) {
 	_, err := h.s3client.CreateBucket(&s3.CreateBucketInput{